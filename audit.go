@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// origin is a place a domain was seen at: a file, or database, and a line, or row, number.
+type origin struct {
+	File string
+	Line int
+}
+
+// before reports whether `o` comes before `other`, by file name then line number.
+func (o origin) before(other origin) bool {
+	if o.File != other.File {
+		return o.File < other.File
+	}
+
+	return o.Line < other.Line
+}
+
+// auditTrail records where every domain was first seen, for the `-audit` file.
+// A nil trail records nothing, so auditing costs nothing when disabled.
+type auditTrail struct {
+	mu    sync.Mutex
+	first map[string]origin
+}
+
+// newAuditTrail returns an empty audit trail.
+func newAuditTrail() *auditTrail {
+	return &auditTrail{first: make(map[string]origin)}
+}
+
+// record notes that `domain` was seen at line `line` of `file`.
+// As files are processed in parallel, the earliest origin by file name then line number is kept,
+// so the trail is the same across runs.
+func (a *auditTrail) record(domain, file string, line int) {
+	if a == nil {
+		return
+	}
+
+	o := origin{File: file, Line: line}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if prev, ok := a.first[domain]; !ok || o.before(prev) {
+		a.first[domain] = o
+	}
+}
+
+// write writes the origin of each of the domains to the file found at `path`, one `domain<TAB>file:line` per line.
+func (a *auditTrail) write(path string, domains []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var b bytes.Buffer
+	for _, domain := range domains {
+		if o, ok := a.first[domain]; ok {
+			fmt.Fprintf(&b, "%v\t%v:%v\n", domain, o.File, o.Line)
+		}
+	}
+
+	return writeFileAtomic(path, b.Bytes())
+}
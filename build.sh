@@ -1,17 +1,22 @@
 #!/usr/bin/env bash
 
+version=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+commit=$(git rev-parse --short HEAD 2>/dev/null || echo none)
+date=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+ldflags="-X main.version=$version -X main.commit=$commit -X main.date=$date"
+
 # Left for backwards compatibility; please use the proper-platform-suffixed binary
-go build main.go
+go build -ldflags "$ldflags" -o main .
 mv main bin/ytblock
 
 # Left for backwards compatibility; please use the proper-platform-suffixed binary
-env GOOS=linux GOARCH=arm GOARM=5 go build main.go
+env GOOS=linux GOARCH=arm GOARM=5 go build -ldflags "$ldflags" -o main .
 mv main bin/ytblock-rpi
 
 package="github.com/foae/pihole-youtube-block"
 package_name="ytblock"
 package_split=(${package//\// })
-platforms=("windows/amd64" "windows/386" "linux/amd64" "linux/386" "linux/arm" "linux/arm64" "darwin/amd64" "darwin/386" "netbsd/amd64" "netbsd/386")
+platforms=("windows/amd64" "windows/386" "linux/amd64" "linux/386" "linux/arm" "linux/arm64" "darwin/amd64" "netbsd/amd64" "netbsd/386")
 
 for platform in "${platforms[@]}"; do
   platform_split=(${platform//\// })
@@ -22,7 +27,7 @@ for platform in "${platforms[@]}"; do
     output_name+='.exe'
   fi
 
-  env GOOS=$GOOS GOARCH=$GOARCH go build -o ./bin/$output_name $package
+  env GOOS=$GOOS GOARCH=$GOARCH go build -ldflags "$ldflags" -o ./bin/$output_name $package
   if [ $? -ne 0 ]; then
     echo 'An error has occurred! Aborting the script execution...'
     exit 1
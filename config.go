@@ -0,0 +1,665 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// defaultLogFileNamePrefix is used when the config doesn't specify `LOG_FILE_NAME_PREFIX`.
+const defaultLogFileNamePrefix = "pihole.log"
+
+// defaultConfigPath is the config file used when the `-config` flag is omitted.
+const defaultConfigPath = "./config.json"
+
+// defaultBatchSize is the number of domains sent to pihole per command when `BATCH_SIZE` is not set.
+const defaultBatchSize = 500
+
+// defaultPiholeTimeoutSeconds limits how long a single pihole command may take when `PIHOLE_TIMEOUT_SECONDS` is not set.
+const defaultPiholeTimeoutSeconds = 60
+
+// defaultPiholeCommand runs pihole when `PIHOLE_COMMAND` is not set.
+const defaultPiholeCommand = "pihole"
+
+// defaultPiholeRetries is how many times a failed pihole command is retried when `PIHOLE_RETRIES` is not set.
+const defaultPiholeRetries = 3
+
+// defaultReadBufferBytes is the size of every read buffer when `READ_BUFFER_BYTES` is not set.
+const defaultReadBufferBytes = 4096
+
+// defaultProfile is the profile used when neither `MATCH_PATTERNS` nor any profile is configured.
+const defaultProfile = "youtube"
+
+// builtinProfiles are the named sets of match patterns shipped with this program.
+var builtinProfiles = map[string][]string{
+	"youtube": {ytblock.DefaultMatchPattern},
+}
+
+// Config describes the configurable options for this program.
+type Config struct {
+	Source                  string              `json:"SOURCE"`
+	FTLDatabase             string              `json:"FTL_DATABASE"`
+	LogsDirectory           string              `json:"PIHOLE_LOGS_DIR"`
+	LogsDirectories         []string            `json:"PIHOLE_LOGS_DIRS"`
+	LogFileNamePrefix       string              `json:"LOG_FILE_NAME_PREFIX"`
+	Recursive               bool                `json:"RECURSIVE"`
+	SkipActiveLog           bool                `json:"SKIP_ACTIVE_LOG"`
+	OutputFileName          string              `json:"COMPILED_FILE_NAME"`
+	PopConfirmationDialogue bool                `json:"POP_CONFIRMATION_DIALOGUE"`
+	DryRun                  bool                `json:"DRY_RUN"`
+	BatchSize               int                 `json:"BATCH_SIZE"`
+	BatchDelay              string              `json:"BATCH_DELAY"`
+	AppendOutput            bool                `json:"APPEND_OUTPUT"`
+	IncrementalOutput       bool                `json:"INCREMENTAL_OUTPUT"`
+	OutputFormat            string              `json:"OUTPUT_FORMAT"`
+	OutputStyle             string              `json:"OUTPUT_STYLE"`
+	ReportFile              string              `json:"REPORT_FILE"`
+	MatchPatterns           []string            `json:"MATCH_PATTERNS"`
+	ExcludePatterns         []string            `json:"EXCLUDE_PATTERNS"`
+	Profiles                map[string][]string `json:"PROFILES"`
+	UseProfiles             []string            `json:"USE_PROFILES"`
+	MinOccurrences          int                 `json:"MIN_OCCURRENCES"`
+	Whitelist               []string            `json:"WHITELIST"`
+	PiholeBackend           string              `json:"PIHOLE_BACKEND"`
+	PiholeAPIURL            string              `json:"PIHOLE_API_URL"`
+	PiholeAPIToken          string              `json:"PIHOLE_API_TOKEN"`
+	HTTPProxyURL            string              `json:"HTTP_PROXY_URL"`
+	PiholeCAFile            string              `json:"PIHOLE_CA_FILE"`
+	PiholeSkipVerify        bool                `json:"PIHOLE_INSECURE_SKIP_VERIFY"`
+	PiholeTimeoutSeconds    int                 `json:"PIHOLE_TIMEOUT_SECONDS"`
+	RemoteHost              string              `json:"REMOTE_HOST"`
+	RemoteKeyFile           string              `json:"REMOTE_KEY_FILE"`
+	RemoteKnownHosts        string              `json:"REMOTE_KNOWN_HOSTS"`
+	PiholeGroup             string              `json:"PIHOLE_GROUP"`
+	PiholeCommand           string              `json:"PIHOLE_COMMAND"`
+	BlockMode               string              `json:"BLOCK_MODE"`
+	CollapseBySN            bool                `json:"COLLAPSE_BY_SN"`
+	VerifyAfterBlock        bool                `json:"VERIFY_AFTER_BLOCK"`
+	PiholeRetries           *int                `json:"PIHOLE_RETRIES"`
+	MaxWorkers              int                 `json:"MAX_WORKERS"`
+	LogFormat               string              `json:"LOG_FORMAT"`
+	SeenStoreFile           string              `json:"SEEN_STORE_FILE"`
+	PromptDefault           string              `json:"PROMPT_DEFAULT"`
+	PromptTimeoutSeconds    int                 `json:"PROMPT_TIMEOUT_SECONDS"`
+	WatchDebounceSeconds    int                 `json:"WATCH_DEBOUNCE_SECONDS"`
+	Quiet                   bool                `json:"QUIET"`
+	MaxFileBytes            int64               `json:"MAX_FILE_BYTES"`
+	WarnFileDomains         int                 `json:"WARN_FILE_DOMAINS"`
+	Since                   string              `json:"SINCE"`
+	OnlyQueries             bool                `json:"ONLY_QUERIES"`
+	ClientFilter            []string            `json:"CLIENT_FILTER"`
+	ReadBufferBytes         int                 `json:"READ_BUFFER_BYTES"`
+
+	// Stats is the number of most seen domains to report, set through the `-stats` flag.
+	Stats int `json:"-"`
+	// ResetStore ignores the seen domains store, replaced once the domains are blocked, set through the `-reset-store` flag.
+	ResetStore bool `json:"-"`
+	// Progress shows an in-place progress line on terminals, set through the `-progress` flag.
+	Progress bool `json:"-"`
+	// Files are the log files to process instead of scanning the logs directories, set through the `-stdin` and `-file` flags.
+	Files []string `json:"-"`
+	// PrintDomains prints the gathered domains once processed, set through the `-file` flag.
+	PrintDomains bool `json:"-"`
+	// AuditFile receives the origin of every domain, set through the `-audit` flag.
+	AuditFile string `json:"-"`
+	// Limit is the most domains that may be sent to pihole at once, set through the `-limit` flag.
+	Limit int `json:"-"`
+	// Diff prints the changes since the previous output file, set through the `-diff` flag.
+	Diff bool `json:"-"`
+	// NoOutput skips writing the output file, set through the `-no-output` flag.
+	NoOutput bool `json:"-"`
+	// CountOnly only prints the number of unique domains, set through the `-count-only` flag.
+	CountOnly bool `json:"-"`
+	// Verbosity selects the debug output, 1 through the `-v` flag and 2 through the `-vv` flag.
+	Verbosity int `json:"-"`
+	// status serves the collected domains over HTTP, set through the `-http` flag.
+	status *statusServer
+	// flusher appends the domains to the output file as they are found, in the long-running modes with `INCREMENTAL_OUTPUT`.
+	flusher *outputFlusher
+	// remote reads the logs and runs the pihole commands of the pihole found at `REMOTE_HOST`, when set.
+	remote *sshRemote
+	// executor runs the pihole commands of the `cli` backend, for real when nil.
+	executor Executor
+
+	// matchers holds the compiled `MatchPatterns`.
+	matchers []*regexp.Regexp
+	// excludes holds the compiled `ExcludePatterns`.
+	excludes []*regexp.Regexp
+	// path is the config file actually loaded, empty when none was found.
+	path string
+	// notFound is the config file looked for, when none was found.
+	notFound string
+}
+
+// NewConfig reads the JSON config file found at `path`, overlays the supported
+// environment variables on top of it and returns the result as a struct.
+// The file is optional when all the required options come from the environment.
+// The default `./config.json` is also looked for next to the executable, see `resolveConfigPath`.
+func NewConfig(path string) (*Config, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ready(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadConfig reads the config like `NewConfig`, without validating it, so the flags can still override it.
+func loadConfig(path string) (*Config, error) {
+	var cfg Config
+
+	path = resolveConfigPath(path)
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		cfg.notFound = path
+	case err != nil:
+		return nil, fmt.Errorf("config: could not read file (%v): %v", path, err)
+	default:
+		defer f.Close()
+		cfg.path = path
+		// A misspelled key would otherwise leave its option silently empty.
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("config: could not decode file (%v): %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	cfg.setDefaults()
+	return &cfg, nil
+}
+
+// ready validates the config and compiles its patterns, once every override is applied.
+func (cfg *Config) ready() error {
+	if err := cfg.Validate(); err != nil {
+		if cfg.notFound != "" {
+			return fmt.Errorf("%v (config file (%v) not found)", err, cfg.notFound)
+		}
+		return err
+	}
+
+	return cfg.compileMatchers()
+}
+
+// resolveConfigPath returns the config file to load for `path`, with its symlinks resolved.
+// When the default `./config.json` is missing from the working directory, as often under a service manager,
+// the `config.json` next to the executable is used instead, if any. Missing files are returned as is.
+func resolveConfigPath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) && path == defaultConfigPath {
+		if exe, err := os.Executable(); err == nil {
+			if exe, err := filepath.EvalSymlinks(exe); err == nil {
+				if candidate := filepath.Join(filepath.Dir(exe), filepath.Base(defaultConfigPath)); fileExists(candidate) {
+					path = candidate
+				}
+			}
+		}
+	}
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+
+	return path
+}
+
+// fileExists reports whether something exists at `path`.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// redactedPlaceholder replaces the secrets of the config printed by `-print-config`.
+const redactedPlaceholder = "REDACTED"
+
+// redacted returns a copy of the config safe to print, its secrets replaced by a placeholder.
+func (cfg *Config) redacted() Config {
+	c := *cfg
+	if c.PiholeAPIToken != "" {
+		c.PiholeAPIToken = redactedPlaceholder
+	}
+	// A password may also hide in the URLs.
+	if u, err := url.Parse(c.PiholeAPIURL); err == nil && u.User != nil {
+		c.PiholeAPIURL = u.Redacted()
+	}
+	if u, err := url.Parse(c.HTTPProxyURL); err == nil && u.User != nil {
+		c.HTTPProxyURL = u.Redacted()
+	}
+
+	return c
+}
+
+// applyEnv overrides the config options with the matching environment variables, when set.
+func (cfg *Config) applyEnv() error {
+	strs := map[string]*string{
+		"PIHOLE_LOGS_DIR":      &cfg.LogsDirectory,
+		"LOG_FILE_NAME_PREFIX": &cfg.LogFileNamePrefix,
+		"COMPILED_FILE_NAME":   &cfg.OutputFileName,
+	}
+	for name, field := range strs {
+		if v, ok := os.LookupEnv(name); ok {
+			*field = v
+		}
+	}
+
+	bools := map[string]*bool{
+		"POP_CONFIRMATION_DIALOGUE": &cfg.PopConfirmationDialogue,
+	}
+	for name, field := range bools {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("config: invalid value (%v) for environment variable (%v): %v", v, name, err)
+		}
+		*field = b
+	}
+
+	return nil
+}
+
+// setDefaults fills in the options left empty in the config file.
+func (cfg *Config) setDefaults() {
+	if cfg.LogFileNamePrefix == "" {
+		cfg.LogFileNamePrefix = defaultLogFileNamePrefix
+	}
+	cfg.PromptDefault = strings.ToLower(cfg.PromptDefault)
+	if cfg.Source == "" {
+		cfg.Source = sourceLogfile
+	}
+	if cfg.FTLDatabase == "" {
+		cfg.FTLDatabase = defaultFTLDatabase
+	}
+	if cfg.OutputFormat == "" {
+		cfg.OutputFormat = outputFormatTXT
+	}
+	if cfg.OutputStyle == "" {
+		cfg.OutputStyle = outputStylePlain
+	}
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = logFormatText
+	}
+	if cfg.BlockMode == "" {
+		cfg.BlockMode = blockModeExact
+	}
+	if cfg.PiholeBackend == "" {
+		cfg.PiholeBackend = backendCLI
+	}
+	if cfg.MinOccurrences <= 0 {
+		cfg.MinOccurrences = 1
+	}
+	if strings.TrimSpace(cfg.PiholeCommand) == "" {
+		cfg.PiholeCommand = defaultPiholeCommand
+	}
+	if cfg.PiholeTimeoutSeconds <= 0 {
+		cfg.PiholeTimeoutSeconds = defaultPiholeTimeoutSeconds
+	}
+	if cfg.PiholeRetries == nil {
+		retries := defaultPiholeRetries
+		cfg.PiholeRetries = &retries
+	}
+	if cfg.WatchDebounceSeconds <= 0 {
+		cfg.WatchDebounceSeconds = defaultWatchDebounceSeconds
+	}
+	if cfg.MaxWorkers <= 0 {
+		cfg.MaxWorkers = runtime.NumCPU()
+	}
+	if cfg.ReadBufferBytes <= 0 {
+		cfg.ReadBufferBytes = defaultReadBufferBytes
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+}
+
+// Validate checks the config for problems, reporting all of them at once.
+func (cfg *Config) Validate() error {
+	var problems []string
+
+	switch cfg.Source {
+	case sourceLogfile:
+		// The files given through `-file` or `-stdin` are read instead of the logs directories.
+		if cfg.Files != nil {
+			break
+		}
+		dirs := cfg.logsDirs()
+		if len(dirs) == 0 {
+			problems = append(problems, "PIHOLE_LOGS_DIR and PIHOLE_LOGS_DIRS are empty")
+		}
+		for _, dir := range dirs {
+			// The directories of a remote pihole are only known once connected.
+			if cfg.RemoteHost != "" {
+				break
+			}
+			if err := checkReadableDir(dir); err != nil {
+				problems = append(problems, fmt.Sprintf("logs directory (%v) is not usable: %v", dir, err))
+			}
+		}
+	case sourceSQLite:
+		if !sqliteAvailable {
+			problems = append(problems, fmt.Sprintf("the (%v) source is not available on (%v/%v)", sourceSQLite, runtime.GOOS, runtime.GOARCH))
+			break
+		}
+		if _, err := os.Stat(cfg.FTLDatabase); err != nil {
+			problems = append(problems, fmt.Sprintf("FTL_DATABASE (%v) is not usable: %v", cfg.FTLDatabase, err))
+		}
+		if cfg.RemoteHost != "" {
+			problems = append(problems, fmt.Sprintf("REMOTE_HOST only supports the (%v) source", sourceLogfile))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown source (%v), use (%v) or (%v)", cfg.Source, sourceLogfile, sourceSQLite))
+	}
+
+	if cfg.OutputFileName == "" {
+		problems = append(problems, "COMPILED_FILE_NAME is empty")
+	} else if cfg.OutputFileName != stdoutFileName {
+		// Fail before any work is done rather than once the output is about to be written.
+		dir := filepath.Dir(outputPath(cfg.OutputFileName))
+		if fi, err := os.Stat(dir); err != nil {
+			problems = append(problems, fmt.Sprintf("the directory (%v) of COMPILED_FILE_NAME is not usable: %v", dir, err))
+		} else if !fi.IsDir() {
+			problems = append(problems, fmt.Sprintf("the directory (%v) of COMPILED_FILE_NAME is not a directory", dir))
+		}
+	}
+
+	patterns, err := cfg.resolvePatterns()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid match pattern (%v): %v", pattern, err))
+		}
+	}
+	for _, pattern := range cfg.ExcludePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid exclude pattern (%v): %v", pattern, err))
+		}
+	}
+
+	if _, err := ytblock.ParseClients(cfg.ClientFilter); err != nil {
+		problems = append(problems, fmt.Sprintf("CLIENT_FILTER: %v", err))
+	}
+
+	if cfg.BatchDelay != "" {
+		if d, err := time.ParseDuration(cfg.BatchDelay); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid BATCH_DELAY (%v), use a duration like 500ms or 2s", cfg.BatchDelay))
+		} else if d < 0 {
+			problems = append(problems, fmt.Sprintf("BATCH_DELAY (%v) cannot be negative", cfg.BatchDelay))
+		}
+	}
+
+	if _, err := parseSince(cfg.Since, time.Now()); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if cfg.WarnFileDomains < 0 {
+		problems = append(problems, fmt.Sprintf("WARN_FILE_DOMAINS (%v) cannot be negative", cfg.WarnFileDomains))
+	}
+	if cfg.PromptTimeoutSeconds < 0 {
+		problems = append(problems, fmt.Sprintf("PROMPT_TIMEOUT_SECONDS (%v) cannot be negative", cfg.PromptTimeoutSeconds))
+	}
+	if cfg.PromptDefault != "" && cfg.PromptDefault != "y" && cfg.PromptDefault != "n" {
+		problems = append(problems, fmt.Sprintf("invalid prompt default (%v), use (y) or (n)", cfg.PromptDefault))
+	}
+
+	switch cfg.OutputFormat {
+	case outputFormatTXT, outputFormatJSON, outputFormatCSV:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown output format (%v), use (%v), (%v) or (%v)", cfg.OutputFormat, outputFormatTXT, outputFormatJSON, outputFormatCSV))
+	}
+	switch cfg.OutputStyle {
+	case outputStylePlain:
+	case outputStyleHosts:
+		if cfg.OutputFormat != outputFormatTXT {
+			problems = append(problems, fmt.Sprintf("OUTPUT_STYLE (%v) is only supported with the (%v) output format", outputStyleHosts, outputFormatTXT))
+		}
+		if cfg.CollapseBySN {
+			problems = append(problems, fmt.Sprintf("OUTPUT_STYLE (%v) cannot hold the wildcards of COLLAPSE_BY_SN", outputStyleHosts))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown output style (%v), use (%v) or (%v)", cfg.OutputStyle, outputStylePlain, outputStyleHosts))
+	}
+	if cfg.AppendOutput && cfg.OutputFileName == stdoutFileName {
+		problems = append(problems, "APPEND_OUTPUT cannot be used when writing to stdout")
+	}
+	if cfg.AppendOutput && cfg.OutputFormat != outputFormatTXT {
+		problems = append(problems, fmt.Sprintf("APPEND_OUTPUT is only supported with the (%v) output format", outputFormatTXT))
+	}
+	if cfg.IncrementalOutput {
+		// The domains are appended as soon as seen, so the file must be kept across cycles and never filtered afterwards.
+		if !cfg.AppendOutput {
+			problems = append(problems, "INCREMENTAL_OUTPUT requires APPEND_OUTPUT")
+		}
+		if strings.HasSuffix(cfg.OutputFileName, gzipSuffix) {
+			problems = append(problems, "INCREMENTAL_OUTPUT cannot append to a gzip compressed COMPILED_FILE_NAME")
+		}
+		if cfg.MinOccurrences > 1 || cfg.CollapseBySN {
+			problems = append(problems, "INCREMENTAL_OUTPUT cannot be used with MIN_OCCURRENCES or COLLAPSE_BY_SN")
+		}
+	}
+
+	switch cfg.LogFormat {
+	case logFormatText, logFormatJSON:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown log format (%v), use (%v) or (%v)", cfg.LogFormat, logFormatText, logFormatJSON))
+	}
+
+	if *cfg.PiholeRetries < 0 {
+		problems = append(problems, fmt.Sprintf("PIHOLE_RETRIES (%v) cannot be negative", *cfg.PiholeRetries))
+	}
+
+	switch cfg.BlockMode {
+	case blockModeExact:
+	case blockModeRegex:
+		if _, err := piholeRegex(patterns); err != nil {
+			problems = append(problems, err.Error())
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown block mode (%v), use (%v) or (%v)", cfg.BlockMode, blockModeExact, blockModeRegex))
+	}
+
+	switch cfg.PiholeBackend {
+	case backendCLI:
+	case backendAPI:
+		if cfg.PiholeAPIURL == "" {
+			problems = append(problems, fmt.Sprintf("PIHOLE_API_URL is required for the (%v) backend", backendAPI))
+		}
+		if cfg.HTTPProxyURL != "" {
+			if _, err := parseProxyURL(cfg.HTTPProxyURL); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		if cfg.PiholeCAFile != "" && cfg.PiholeSkipVerify {
+			problems = append(problems, "PIHOLE_CA_FILE and PIHOLE_INSECURE_SKIP_VERIFY cannot be used together")
+		} else if _, err := cfg.apiTLSConfig(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown backend (%v), use (%v) or (%v)", cfg.PiholeBackend, backendCLI, backendAPI))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("config: %v", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// resolvePatterns returns the `MatchPatterns` along with the patterns of every profile in use.
+// Without any pattern or profile the built-in default profile is used.
+// A custom profile takes precedence over a built-in one with the same name.
+func (cfg *Config) resolvePatterns() ([]string, error) {
+	patterns := append([]string{}, cfg.MatchPatterns...)
+
+	profiles := cfg.UseProfiles
+	if len(profiles) == 0 && len(patterns) == 0 {
+		profiles = []string{defaultProfile}
+	}
+
+	for _, name := range profiles {
+		p, ok := cfg.Profiles[name]
+		if !ok {
+			p, ok = builtinProfiles[name]
+		}
+		if !ok {
+			return patterns, fmt.Errorf("unknown profile (%v)", name)
+		}
+		patterns = append(patterns, p...)
+	}
+
+	return patterns, nil
+}
+
+// compileMatchers compiles the resolved match patterns and the exclude patterns, replacing the current ones.
+func (cfg *Config) compileMatchers() error {
+	patterns, err := cfg.resolvePatterns()
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+
+	matchers := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		m, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("config: invalid match pattern (%v): %v", pattern, err)
+		}
+		matchers = append(matchers, m)
+	}
+	cfg.matchers = matchers
+
+	excludes := make([]*regexp.Regexp, 0, len(cfg.ExcludePatterns))
+	for _, pattern := range cfg.ExcludePatterns {
+		m, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("config: invalid exclude pattern (%v): %v", pattern, err)
+		}
+		excludes = append(excludes, m)
+	}
+	cfg.excludes = excludes
+
+	return nil
+}
+
+// checkReadableDir returns an error unless `dir` is an existing, readable directory.
+func checkReadableDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+
+	return nil
+}
+
+// newExtractor returns the extractor of the domains as configured, with the compiled `MatchPatterns`.
+// Where every domain is found is recorded to `audit`, if not nil.
+func (cfg *Config) newExtractor(now time.Time, audit *auditTrail) (*ytblock.Extractor, error) {
+	opts := ytblock.Options{
+		Matchers:        cfg.matchers,
+		Excludes:        cfg.excludes,
+		Prefix:          cfg.LogFileNamePrefix,
+		Recursive:       cfg.Recursive,
+		SkipActive:      cfg.SkipActiveLog,
+		MaxFileBytes:    cfg.MaxFileBytes,
+		ReadBufferBytes: cfg.ReadBufferBytes,
+		Since:           cfg.since(now),
+		OnlyQueries:     cfg.OnlyQueries,
+		Clients:         cfg.ClientFilter,
+		Workers:         cfg.MaxWorkers,
+		Infof:           infof,
+		Warnf:           warnf,
+		Verbosity:       cfg.Verbosity,
+		Debugf:          debugf,
+	}
+	if audit != nil {
+		opts.OnMatch = audit.record
+	}
+	if cfg.remote != nil {
+		opts.Open = cfg.remote.open
+	}
+
+	return ytblock.NewExtractor(opts)
+}
+
+// logsDirs returns every configured logs directory: `LogsDirectory` followed by `LogsDirectories`,
+// without empty entries nor duplicates.
+func (cfg *Config) logsDirs() []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, dir := range append([]string{cfg.LogsDirectory}, cfg.LogsDirectories...) {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+
+	return dirs
+}
+
+// since returns the time before which log lines are ignored, the zero time when `SINCE` is not set.
+// A duration is relative to `now`, so it moves along in long-running modes.
+func (cfg *Config) since(now time.Time) time.Time {
+	ts, _ := parseSince(cfg.Since, now)
+	return ts
+}
+
+// parseSince parses `SINCE`, either a duration back from `now` like `168h` or an RFC3339 timestamp.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	ts, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SINCE (%v), use a duration like 168h or an RFC3339 timestamp", v)
+	}
+
+	return ts, nil
+}
+
+// promptTimeout returns how long the confirmation dialogue waits for an answer, zero meaning forever.
+func (cfg *Config) promptTimeout() time.Duration {
+	return time.Duration(cfg.PromptTimeoutSeconds) * time.Second
+}
+
+// piholeTimeout returns the configured pihole timeout as a duration.
+func (cfg *Config) piholeTimeout() time.Duration {
+	return time.Duration(cfg.PiholeTimeoutSeconds) * time.Second
+}
+
+// batchDelay returns the `BATCH_DELAY` between two consecutive batches sent to pihole, zero when not set.
+func (cfg *Config) batchDelay() time.Duration {
+	d, _ := time.ParseDuration(cfg.BatchDelay)
+	return d
+}
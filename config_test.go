@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewConfigTestdata(t *testing.T) {
+	cfg, err := NewConfig("testdata/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"logs directory", cfg.LogsDirectory, "./testdata/"},
+		{"output file", cfg.OutputFileName, stdoutFileName},
+		{"pihole command", cfg.PiholeCommand, "./testdata/fake-pihole"},
+		{"confirmation dialogue", cfg.PopConfirmationDialogue, false},
+		{"default backend", cfg.PiholeBackend, backendCLI},
+		{"default block mode", cfg.BlockMode, blockModeExact},
+		{"default prefix", cfg.LogFileNamePrefix, "pihole.log"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v: got (%v), want (%v)", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("PIHOLE_LOGS_DIR", "/var/log/pihole")
+	t.Setenv("COMPILED_FILE_NAME", "")
+	t.Setenv("POP_CONFIRMATION_DIALOGUE", "true")
+
+	cfg := Config{LogsDirectory: "./logs", LogFileNamePrefix: "dnsmasq.log", OutputFileName: "out.txt"}
+	if err := cfg.applyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"overridden", cfg.LogsDirectory, "/var/log/pihole"},
+		{"not set", cfg.LogFileNamePrefix, "dnsmasq.log"},
+		{"set empty", cfg.OutputFileName, ""},
+		{"bool", cfg.PopConfirmationDialogue, true},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v: got (%v), want (%v)", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnvInvalidBool(t *testing.T) {
+	t.Setenv("POP_CONFIRMATION_DIALOGUE", "maybe")
+
+	var cfg Config
+	if err := cfg.applyEnv(); err == nil {
+		t.Error("got no error for an invalid boolean")
+	}
+}
+
+func TestLogsDirs(t *testing.T) {
+	tests := []struct {
+		name  string
+		dir   string
+		extra []string
+		want  []string
+	}{
+		{"single", "/var/log", nil, []string{"/var/log"}},
+		{"several", "/var/log", []string{"/mnt/a", "/mnt/b"}, []string{"/var/log", "/mnt/a", "/mnt/b"}},
+		{"only the list", "", []string{"/mnt/a"}, []string{"/mnt/a"}},
+		{"duplicates and empty entries", "/var/log", []string{"", "/mnt/a", "/var/log", "/mnt/a"}, []string{"/var/log", "/mnt/a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{LogsDirectory: tt.dir, LogsDirectories: tt.extra}
+			if got := cfg.logsDirs(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewConfigUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"PIHOLE_LOGS_DIR": "./testdata/", "POP_CONFIRMATION_DIALOG": false}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewConfig(path)
+	if err == nil {
+		t.Fatal("got no error for a misspelled key")
+	}
+	if !strings.Contains(err.Error(), "POP_CONFIRMATION_DIALOG") {
+		t.Errorf("got error (%v), want it to name the unknown key", err)
+	}
+}
+
+// TestFilesWithoutLogsDir checks `-file` and `-stdin` run without a usable logs directory, as theirs is never read.
+func TestFilesWithoutLogsDir(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	settings := `{"PIHOLE_LOGS_DIR": "` + filepath.ToSlash(filepath.Join(dir, "missing")) + `", "COMPILED_FILE_NAME": "` + filepath.ToSlash(filepath.Join(dir, "blacklist.txt")) + `", "POP_CONFIRMATION_DIALOGUE": false}`
+	if err := ioutil.WriteFile(path, []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewConfig(path); err == nil {
+		t.Fatal("got no error for a missing logs directory")
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Files = []string{filepath.Join("testdata", "pihole.log")}
+	if err := cfg.ready(); err != nil {
+		t.Fatalf("got error (%v) with a file given, want none", err)
+	}
+	ex := new(recordingExecutor)
+	cfg.executor = ex
+
+	if err := run(context.Background(), cfg, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(ex.calls) != 1 {
+		t.Errorf("got pihole commands %v, want one", joinCalls(ex.calls))
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchDebounceSeconds is the minimum time between two scans in watch mode when `WATCH_DEBOUNCE_SECONDS` is not set.
+const defaultWatchDebounceSeconds = 30
+
+// watch keeps running, re-scanning the logs and blocking the newly seen domains every time
+// the log files change. A burst of changes triggers at most one scan per debounce interval.
+// It returns once `ctx` is cancelled.
+func watch(ctx context.Context, cfg *Config, w io.Writer) error {
+	if cfg.SeenStoreFile == "" {
+		return &exitError{exitStartupError, fmt.Errorf("watch mode requires SEEN_STORE_FILE so that only new domains are blocked")}
+	}
+
+	if cfg.Source != sourceLogfile {
+		return &exitError{exitStartupError, fmt.Errorf("watch mode requires the (%v) source, use -interval instead", sourceLogfile)}
+	}
+	if cfg.remote != nil {
+		return &exitError{exitStartupError, fmt.Errorf("watch mode cannot watch the logs of REMOTE_HOST, use -interval instead")}
+	}
+
+	dirs := cfg.logsDirs()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return &exitError{exitStartupError, fmt.Errorf("could not start watching (%v): %v", strings.Join(dirs, ", "), err)}
+	}
+	defer watcher.Close()
+
+	for _, dir := range dirs {
+		if err := watchDirs(watcher, dir, cfg.Recursive); err != nil {
+			return &exitError{exitStartupError, fmt.Errorf("could not start watching (%v): %v", dir, err)}
+		}
+	}
+
+	// Nobody is around to answer the confirmation dialogue.
+	cfg.PopConfirmationDialogue = false
+	debounce := time.Duration(cfg.WatchDebounceSeconds) * time.Second
+
+	infof("Watching (%v) for changes, scanning at most every (%v).", strings.Join(dirs, ", "), debounce)
+	if stop := runCycle(ctx, cfg, w); stop {
+		return nil
+	}
+
+	var scan <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			infof("Stopped watching.")
+			return nil
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !watchEvent(watcher, cfg, ev) {
+				continue
+			}
+			if scan == nil {
+				scan = time.After(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			warnf("watch: %v", err)
+		case <-scan:
+			scan = nil
+			if stop := runCycle(ctx, cfg, w); stop {
+				return nil
+			}
+		}
+	}
+}
+
+// poll keeps running, re-scanning the logs and blocking the newly seen domains every `interval`.
+// It returns once `ctx` is cancelled.
+func poll(ctx context.Context, cfg *Config, w io.Writer, interval time.Duration) error {
+	if cfg.SeenStoreFile == "" {
+		return &exitError{exitStartupError, fmt.Errorf("interval mode requires SEEN_STORE_FILE so that only new domains are blocked")}
+	}
+
+	// Nobody is around to answer the confirmation dialogue.
+	cfg.PopConfirmationDialogue = false
+
+	infof("Scanning (%v) every (%v).", strings.Join(cfg.logsDirs(), ", "), interval)
+	for {
+		if stop := runCycle(ctx, cfg, w); stop {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			infof("Stopped polling.")
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// runCycle runs a single scan-and-block cycle of a long-running mode.
+// Errors are logged rather than returned so the next cycle still happens.
+// It reports whether the program should stop because it was interrupted.
+func runCycle(ctx context.Context, cfg *Config, w io.Writer) bool {
+	ts := time.Now()
+	err := run(ctx, cfg, w)
+	infof("Cycle finished in (%v).", time.Since(ts))
+
+	switch {
+	case exitCode(err) == exitInterrupted:
+		infof("Stopped.")
+		return true
+	case err != nil:
+		errorf("cycle failed: %v", err)
+	}
+
+	return false
+}
+
+// watchEvent reports whether the event calls for a scan. In recursive mode, the directories
+// created under the watched ones are watched as well, and scanned for the logs they may hold.
+func watchEvent(watcher *fsnotify.Watcher, cfg *Config, ev fsnotify.Event) bool {
+	// Only the permissions or a timestamp changed, not the content.
+	if ev.Op == fsnotify.Chmod {
+		return false
+	}
+	if cfg.Recursive && ev.Has(fsnotify.Create) {
+		if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+			if err := watchDirs(watcher, ev.Name, true); err != nil {
+				warnf("Not watching the new directory (%v): %v", ev.Name, err)
+				return false
+			}
+			debugf("Watching the new directory (%v).", ev.Name)
+			return true
+		}
+	}
+
+	name := filepath.Base(ev.Name)
+	return strings.HasPrefix(name, cfg.LogFileNamePrefix) && !(cfg.SkipActiveLog && name == cfg.LogFileNamePrefix)
+}
+
+// watchDirs adds `dir` to the watcher, along with all its subdirectories when `recursive` is set.
+// Unreadable subdirectories are skipped with a warning.
+func watchDirs(watcher *fsnotify.Watcher, dir string, recursive bool) error {
+	if !recursive {
+		return watcher.Add(dir)
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil && path == dir:
+			return err
+		case err != nil:
+			warnf("Not watching unreadable entry (%v): %v", path, err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		case d.IsDir():
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
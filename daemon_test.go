@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatchEvent(t *testing.T) {
+	dir := t.TempDir()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	if err := watchDirs(watcher, dir, true); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{LogFileNamePrefix: "pihole.log", Recursive: true}
+
+	log := filepath.Join(dir, "pihole.log")
+	tests := []struct {
+		name string
+		ev   fsnotify.Event
+		want bool
+	}{
+		{"write", fsnotify.Event{Name: log, Op: fsnotify.Write}, true},
+		{"chmod only", fsnotify.Event{Name: log, Op: fsnotify.Chmod}, false},
+		{"chmod and write", fsnotify.Event{Name: log, Op: fsnotify.Chmod | fsnotify.Write}, true},
+		{"other file", fsnotify.Event{Name: filepath.Join(dir, "FTL.log"), Op: fsnotify.Write}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := watchEvent(watcher, cfg, tt.ev); got != tt.want {
+				t.Errorf("got scan (%v), want (%v)", got, tt.want)
+			}
+		})
+	}
+
+	// A new directory is watched along with its own subdirectories.
+	sub := filepath.Join(dir, "2024", "01")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !watchEvent(watcher, cfg, fsnotify.Event{Name: filepath.Join(dir, "2024"), Op: fsnotify.Create}) {
+		t.Error("got no scan for a new directory")
+	}
+	got := watcher.WatchList()
+	sort.Strings(got)
+	if want := []string{dir, filepath.Join(dir, "2024"), sub}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got watched %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// outputFlushInterval is how often the domains found by `INCREMENTAL_OUTPUT` are appended to the output file.
+const outputFlushInterval = 5 * time.Second
+
+// outputFlusher appends the newly found domains to the output file while the logs are being scanned,
+// so a long-running process keeps the file up to date between two cycles.
+// The domains are sent to `domains` by the domain map of every cycle, see `ytblock.DomainMap.Notify`.
+type outputFlusher struct {
+	path      string
+	style     string
+	whitelist []string
+	domains   chan string
+	done      chan struct{}
+
+	// mu serialises the appends with the rewrites of the whole output file at the end of a cycle.
+	mu sync.Mutex
+	// written holds the domains already in the file, or about to be, so none is written twice.
+	written map[string]struct{}
+	pending []string
+}
+
+// newOutputFlusher starts appending the domains sent to its channel to the configured output file,
+// every `outputFlushInterval`, until `close` is called.
+func newOutputFlusher(cfg *Config) (*outputFlusher, error) {
+	path := outputPath(cfg.OutputFileName)
+	previous, err := readOutputFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f := &outputFlusher{
+		path:      path,
+		style:     cfg.OutputStyle,
+		whitelist: cfg.Whitelist,
+		domains:   make(chan string, 1024),
+		done:      make(chan struct{}),
+		written:   parseDomains(previous),
+	}
+	go f.loop()
+
+	return f, nil
+}
+
+// loop gathers the domains sent to the channel and appends them to the file periodically.
+// Once the channel is closed, the last pending domains are written before returning.
+func (f *outputFlusher) loop() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(outputFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case domain, ok := <-f.domains:
+			if !ok {
+				f.flush()
+				return
+			}
+			f.add(domain)
+		case <-ticker.C:
+			f.flush()
+		}
+	}
+}
+
+// add queues the domain for the next flush, unless it is whitelisted or already in the file.
+func (f *outputFlusher) add(domain string) {
+	for _, p := range f.whitelist {
+		if ytblock.MatchesDomainPattern(domain, p) {
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.written[domain]; ok {
+		return
+	}
+	f.written[domain] = struct{}{}
+	f.pending = append(f.pending, domain)
+}
+
+// flush appends the pending domains to the file and syncs it to disk.
+// On failure the domains are kept pending, and tried again on the next flush.
+func (f *outputFlusher) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.pending) == 0 {
+		return
+	}
+
+	content := domainsText(f.pending)
+	if f.style == outputStyleHosts {
+		content = hostsText(f.pending)
+	}
+	if err := appendFileSync(f.path, content); err != nil {
+		warnf("Could not append (%v) domains to the output file (%v): %v", len(f.pending), f.path, err)
+		return
+	}
+
+	debugf("Appended (%v) domains to the output file (%v).", len(f.pending), f.path)
+	f.pending = nil
+}
+
+// rewrite runs `write`, replacing the whole output file, once the pending domains are flushed,
+// and without any append happening meanwhile. The domains of `domains` count as written from then on.
+func (f *outputFlusher) rewrite(domains []string, write func() error) error {
+	f.flush()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := write(); err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		f.written[domain] = struct{}{}
+	}
+
+	return nil
+}
+
+// close stops the flusher once the last pending domains are written.
+// No domain may be sent to the channel anymore.
+func (f *outputFlusher) close() {
+	close(f.domains)
+	<-f.done
+}
+
+// appendFileSync appends the content to the file found at `path`, creating it if needed,
+// and waits for it to reach the disk.
+func appendFileSync(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(content); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Supported values for the `LOG_FORMAT` config option.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging configures the output of the `log` package according to the given format.
+// The text format keeps the standard logger untouched; the json format routes every
+// log line through a JSON handler emitting the `level`, `msg`, `file` and `timestamp` fields.
+// When `quiet` is set, only warnings and errors are logged; the debug output of `verbosity`
+// above zero is logged otherwise.
+func setupLogging(format string, quiet bool, verbosity int) error {
+	quietLogging = quiet
+	logVerbosity = verbosity
+
+	switch format {
+	case logFormatText:
+		return nil
+	case logFormatJSON:
+		h := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+			AddSource:   true,
+			ReplaceAttr: replaceLogAttr,
+		})
+		// The source of log lines is only captured when the standard logger asks for file names.
+		log.SetFlags(log.Lshortfile)
+		slog.SetDefault(slog.New(h))
+		jsonLogging = true
+		return nil
+	default:
+		return fmt.Errorf("logging: unknown format (%v), use (%v) or (%v)", format, logFormatText, logFormatJSON)
+	}
+}
+
+// replaceLogAttr renames the built-in slog attributes to the field names used by this program.
+func replaceLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+
+	switch a.Key {
+	case slog.TimeKey:
+		a.Key = "timestamp"
+	case slog.SourceKey:
+		if src, ok := a.Value.Any().(*slog.Source); ok {
+			return slog.String("file", fmt.Sprintf("%v:%v", filepath.Base(src.File), src.Line))
+		}
+	}
+
+	return a
+}
+
+// Logging state, set once by `setupLogging` before any other goroutine starts.
+var (
+	quietLogging bool
+	jsonLogging  bool
+	logVerbosity int
+)
+
+// infof logs an informational message, unless quiet logging is enabled.
+func infof(format string, args ...interface{}) {
+	logAt(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// debugf logs debug output, when a verbosity was set through the `-v` or `-vv` flags.
+// The message is not even formatted otherwise.
+func debugf(format string, args ...interface{}) {
+	if logVerbosity == 0 {
+		return
+	}
+	logAt(slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+// warnf logs a warning. Warnings are shown even when quiet logging is enabled.
+func warnf(format string, args ...interface{}) {
+	logAt(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+// errorf logs an error. Errors are shown even when quiet logging is enabled.
+func errorf(format string, args ...interface{}) {
+	logAt(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+// logAt writes the message with the standard logger, keeping the level and the
+// caller's source in the json format.
+func logAt(level slog.Level, msg string) {
+	if quietLogging && level < slog.LevelWarn {
+		return
+	}
+	if !jsonLogging {
+		log.Output(3, msg)
+		return
+	}
+
+	// skip [runtime.Callers, logAt, debugf/infof/warnf/errorf]
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	_ = slog.Default().Handler().Handle(context.Background(), r)
+}
@@ -2,256 +2,679 @@ package main
 
 import (
 	"bufio"
-	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
 )
 
-// Alternative regex: ^r[0-9]+-*sn-[A-Za-z0-9]*-*.googlevideo.com$
-var rgx = regexp.MustCompile(`(?m)r([0-9])---sn-(.*?)\.googlevideo\.com`)
+// Build metadata, injected at build time with `-ldflags "-X main.version=... -X main.commit=... -X main.date=..."`.
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
 
-// Config describes the configurable options for this program.
-type Config struct {
-	LogsDirectory           string `json:"PIHOLE_LOGS_DIR"`
-	LogFileNamePrefix       string `json:"LOG_FILE_NAME_PREFIX"`
-	OutputFileName          string `json:"COMPILED_FILE_NAME"`
-	PopConfirmationDialogue bool   `json:"POP_CONFIRMATION_DIALOGUE"`
+// FileMatches pairs a processed log file with the number of lines read and of matches found in it.
+type FileMatches struct {
+	File    string
+	Lines   int
+	Matches int
 }
 
-// DomainMap holds the gathered domains from the log files.
-// The underlying map consists of key: domain, value: number of occurrences.
-type DomainMap struct {
-	m map[string]int
-	l sync.Locker
+// Exit codes returned by the program.
+const (
+	// exitOK means every file was processed and the domains were handled as requested.
+	exitOK = 0
+	// exitStartupError means the run could not start (e.g. bad config) or aborted on a fatal error.
+	exitStartupError = 1
+	// exitPartialFailure means the run completed but some log files could not be processed.
+	exitPartialFailure = 2
+	// exitPiholeFailed means the domains could not be sent to pihole.
+	exitPiholeFailed = 3
+	// exitInterrupted means the run was stopped by SIGINT or SIGTERM before making any changes.
+	exitInterrupted = 130
+)
+
+// errInterrupted is returned when the run is stopped by a signal.
+var errInterrupted = &exitError{exitInterrupted, errors.New("interrupted, no changes made")}
+
+// exitError carries the exit code the program should terminate with.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// exitCode translates an error returned by `run` into the program's exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+
+	return exitStartupError
 }
 
 func main() {
-	ts := time.Now()
-	lock := new(sync.Mutex)
+	if err := start(); err != nil {
+		errorf("%v", err)
+		os.Exit(exitCode(err))
+	}
+}
 
-	cfg, err := NewConfig()
-	if err != nil {
-		log.Fatalf("unable to start: %v", err)
+// start parses the flags and the config, then runs the selected mode until it is done or interrupted.
+// Every error bubbles up to `main`, so deferred cleanup always runs before exiting.
+func start() error {
+	configPath := flag.String("config", defaultConfigPath, "path to the JSON config file")
+	output := flag.String("o", "", "write the domains to `path` instead of COMPILED_FILE_NAME, or to stdout when -")
+	dryRun := flag.Bool("dry-run", false, "write and print the collected domains without sending them to pihole")
+	resetStore := flag.Bool("reset-store", false, "ignore the store of already blocked domains, replacing it once the domains are blocked")
+	singleFile := flag.String("file", "", "process only the log file found at `path` and print its domains, instead of scanning the logs directory")
+	stdinMode := flag.Bool("stdin", false, "process the log files whose paths are read from stdin, one per line, instead of scanning the logs directory")
+	quiet := flag.Bool("quiet", false, "only log warnings and errors")
+	showProgress := flag.Bool("progress", false, "show the percentage of processed files, updated in place, when running in a terminal")
+	stats := flag.Int("stats", 0, "print the top `N` most frequently seen domains with their hit counts")
+	watchMode := flag.Bool("watch", false, "keep running and block newly seen domains whenever the log files change")
+	interval := flag.Duration("interval", 0, "keep running and re-scan the logs every `duration`, e.g. 5m")
+	pattern := flag.String("pattern", "", "use the `regex` as the sole match pattern, instead of the configured patterns and profiles")
+	profiles := flag.String("profiles", "", "comma separated `list` of match profiles to use, e.g. youtube,twitch")
+	unblockMode := flag.Bool("unblock", false, "whitelist the domains of the output file, or of the list files given as arguments, instead of scanning the logs")
+	httpAddr := flag.String("http", "", "serve the collected domains and metrics on `address`, e.g. :8080, in -watch or -interval mode")
+	limit := flag.Int("limit", 0, "refuse to block anything when more than `N` domains would be sent to pihole")
+	auditFile := flag.String("audit", "", "write to `path` the file and line each domain was first seen on")
+	verbose := flag.Bool("v", false, "log the number of lines and matches of every file")
+	veryVerbose := flag.Bool("vv", false, "like -v, and also log every matched line with its file")
+	diffMode := flag.Bool("diff", false, "print the domains added and removed since the previous output file, before replacing it")
+	mergeMode := flag.Bool("merge", false, "write to COMPILED_FILE_NAME the union of the domain list files given as arguments, instead of scanning the logs")
+	exportMode := flag.Bool("export-blacklist", false, "seed SEEN_STORE_FILE with the matching domains already on the pihole blacklist, instead of scanning the logs")
+	noOutput := flag.Bool("no-output", false, "send the domains straight to pihole without writing COMPILED_FILE_NAME")
+	countOnly := flag.Bool("count-only", false, "only print the number of unique domains found, without writing nor blocking anything")
+	printConfig := flag.Bool("print-config", false, "print the effective config, once merged with the environment and the flags, as JSON and exit")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("ytblock %v (commit %v, built %v)\n", version, commit, date)
+		return nil
 	}
 
-	// Read all files from the configured `LogsDirectory`
-	files, err := ioutil.ReadDir(cfg.LogsDirectory)
+	// The config is validated once the flags are applied, as they change what is checked.
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		log.Fatalf("could not read files from the configured directory (%v): %v", cfg.LogsDirectory, err)
+		return fmt.Errorf("unable to start: %v", err)
+	}
+
+	if *output != "" {
+		cfg.OutputFileName = *output
+	}
+
+	if *dryRun {
+		cfg.DryRun = true
+	}
+	cfg.Stats = *stats
+	cfg.Progress = *showProgress
+	cfg.AuditFile = *auditFile
+	cfg.Limit = *limit
+	cfg.Diff = *diffMode
+	if cfg.Diff && cfg.OutputFileName == stdoutFileName {
+		return fmt.Errorf("unable to start: -diff needs an output file to compare with, not stdout")
 	}
 
-	// Filter through the files.
-	filesOfInterest := make([]string, 0, 1024)
-	for _, f := range files {
-		switch {
-		case f.IsDir():
-			continue
-		case strings.HasPrefix(f.Name(), "pihole.log"):
-			filesOfInterest = append(filesOfInterest, f.Name())
+	if *noOutput {
+		if *output != "" || cfg.Diff || *unblockMode {
+			return fmt.Errorf("unable to start: -no-output cannot be used with -o, -diff or -unblock")
 		}
+		cfg.NoOutput = true
 	}
 
-	// Keep track of all gathered domains.
-	compiledMap := NewDomainMap(lock)
-	var wg sync.WaitGroup
-	wg.Add(len(filesOfInterest))
+	if *pattern != "" && *profiles != "" {
+		return fmt.Errorf("unable to start: -pattern and -profiles cannot be used together")
+	}
+	if *pattern != "" {
+		if _, err := regexp.Compile(*pattern); err != nil {
+			return fmt.Errorf("unable to start: invalid -pattern (%v): %v", *pattern, err)
+		}
+		cfg.MatchPatterns = []string{*pattern}
+		cfg.UseProfiles = nil
+	}
+	if *profiles != "" {
+		cfg.UseProfiles = splitList(*profiles)
+	}
+	cfg.ResetStore = *resetStore
 
-	// For each file of interest, read it line-by-line.
-	for _, fileName := range filesOfInterest {
-		f := cfg.LogsDirectory + fileName
-		go processFile(f, compiledMap, &wg)
+	if *stdinMode && *singleFile != "" {
+		return fmt.Errorf("unable to start: -stdin and -file cannot be used together")
+	}
+	if *singleFile != "" {
+		cfg.Files = []string{*singleFile}
+		cfg.PrintDomains = true
 	}
+	if *stdinMode {
+		if cfg.PopConfirmationDialogue && !cfg.DryRun {
+			return fmt.Errorf("unable to start: -stdin cannot be used with the confirmation dialogue, set POP_CONFIRMATION_DIALOGUE to false or use -dry-run")
+		}
 
-	fmt.Println(">>> Waiting for all jobs to finish...")
-	wg.Wait()
+		cfg.Files, err = readLines(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("unable to start: could not read the file list from stdin: %v", err)
+		}
+	}
 
-	totalCollectedDomains := len(compiledMap.Domains())
-	fmt.Printf(">>> Done: (%v) unique extracted domains written to (%v) in (%v)\n",
-		totalCollectedDomains,
-		cfg.OutputFileName,
-		time.Since(ts),
-	)
+	if err := cfg.ready(); err != nil {
+		return fmt.Errorf("unable to start: %v", err)
+	}
 
-	// Write to a file the gathered domains.
-	// TODO: maybe give the option to append if file exists and not overwrite?
-	f, err := os.Create("./" + cfg.OutputFileName)
-	if err != nil {
-		log.Fatalf("could not write output to file (%v)", cfg.OutputFileName)
+	if *quiet {
+		cfg.Quiet = true
+	}
+	if *countOnly {
+		if *watchMode || *interval > 0 || *unblockMode {
+			return fmt.Errorf("unable to start: -count-only cannot be used with -watch, -interval or -unblock")
+		}
+		// Only the count is printed, along with the warnings and errors.
+		cfg.CountOnly = true
+		cfg.Quiet = true
+	}
+	switch {
+	case *veryVerbose:
+		cfg.Verbosity = 2
+	case *verbose:
+		cfg.Verbosity = 1
+	}
+	if cfg.Quiet && cfg.Verbosity > 0 {
+		return fmt.Errorf("unable to start: -v and -vv cannot be combined with quiet logging")
 	}
 
-	for domain, _ := range compiledMap.Domains() {
-		if _, err := f.WriteString(domain + "\n"); err != nil {
-			log.Printf("skipped: could not write domain (%v) to file (%v): %v", domain, cfg.OutputFileName, err)
-			continue
+	if *printConfig {
+		b, err := json.MarshalIndent(cfg.redacted(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to print the config: %v", err)
 		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", b)
+		return err
 	}
 
-	// Directly send the found domains to pihole, if the config says so.
-	if cfg.PopConfirmationDialogue == false {
-		log.Printf("Automatically adding (%v) domains to the blacklist...", totalCollectedDomains)
+	if err := setupLogging(cfg.LogFormat, cfg.Quiet, cfg.Verbosity); err != nil {
+		return fmt.Errorf("unable to start: %v", err)
+	}
+	if cfg.path != "" {
+		infof("Loaded the config file (%v).", cfg.path)
+	} else {
+		infof("No config file found at (%v), using the environment and the defaults.", *configPath)
+	}
+	if cfg.PiholeBackend == backendAPI && cfg.PiholeSkipVerify {
+		warnf("PIHOLE_INSECURE_SKIP_VERIFY is set: the certificate of the pihole is not checked, anyone on the network can impersonate it.")
+	}
+
+	// Stop gracefully on Ctrl-C or when the service manager asks to.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *watchMode && *interval > 0 {
+		return fmt.Errorf("unable to start: -watch and -interval cannot be used together")
+	}
+	if *exportMode && (*watchMode || *interval > 0 || *unblockMode || cfg.CountOnly) {
+		return fmt.Errorf("unable to start: -export-blacklist cannot be used with -watch, -interval, -unblock or -count-only")
+	}
+	if *mergeMode && (*watchMode || *interval > 0 || *unblockMode || *exportMode || cfg.CountOnly || cfg.NoOutput) {
+		return fmt.Errorf("unable to start: -merge cannot be used with -watch, -interval, -unblock, -export-blacklist, -count-only or -no-output")
+	}
 
-		out, err := execPihole(compiledMap.DomainsToString())
+	// A remote pihole gets its logs read, and its commands run, over SSH.
+	if cfg.RemoteHost != "" {
+		cfg.remote, err = newSSHRemote(cfg)
 		if err != nil {
-			log.Fatalf("could not send `blacklist domains` command to pihole: %v", err)
+			return fmt.Errorf("unable to start: %v", err)
+		}
+		defer cfg.remote.close()
+		if cfg.executor == nil {
+			cfg.executor = cfg.remote
 		}
-
-		log.Printf("Output from pihole: %s", out)
-		log.Println("Finished.")
-		os.Exit(0)
 	}
 
-	// Otherwise pop up a confirmation dialogue.
-	r := bufio.NewReader(os.Stdin)
-	fmt.Println("-----------")
-	fmt.Printf("Would you like to stick those (%v) collected domains into *your* pihole? (y/n)\n",
-		totalCollectedDomains,
-	)
-	fmt.Println("-----------")
-
-	for {
-		rn, _, err := r.ReadRune()
-		switch {
-		case err != nil:
-			log.Fatalf("could not read input: %v", err)
-		case rn == 'Y', rn == 'y':
-			log.Println("> Yes. Please wait.")
-			log.Printf("Adding (%v) domains to the blacklist...", totalCollectedDomains)
-
-			out, err := execPihole(compiledMap.DomainsToString())
-			if err != nil {
-				log.Fatalf("could not send `blacklist domains` command to pihole: %v", err)
-			}
+	var serverDone <-chan struct{}
+	if *httpAddr != "" {
+		if !*watchMode && *interval == 0 {
+			return fmt.Errorf("unable to start: -http requires -watch or -interval")
+		}
 
-			log.Printf("Output from pihole: %s", out)
-			log.Println("Finished.")
-			os.Exit(0)
-		case rn == 'N', rn == 'n':
-			log.Println("No is a no. Bye.")
-			os.Exit(0)
-		default:
-			log.Printf("Your key (%v) is not supported. Use: Y, y, N, n", rn)
+		cfg.status = new(statusServer)
+		serverDone, err = cfg.status.start(ctx, *httpAddr)
+		if err != nil {
+			return fmt.Errorf("unable to start: %v", err)
 		}
 	}
-}
 
-// Insert takes care of adding domains the the domain map.
-func (dm DomainMap) Insert(s string) {
-	dm.l.Lock()
-	dm.m[s]++
-	dm.l.Unlock()
-}
+	runner := run
+	switch {
+	case *unblockMode:
+		runner = func(ctx context.Context, cfg *Config, w io.Writer) error {
+			return unblock(ctx, cfg, w, flag.Args())
+		}
+	case *mergeMode:
+		runner = func(ctx context.Context, cfg *Config, w io.Writer) error {
+			return merge(ctx, cfg, w, flag.Args())
+		}
+	case *exportMode:
+		runner = exportBlacklist
+	case *watchMode:
+		runner = watch
+	case *interval > 0:
+		runner = func(ctx context.Context, cfg *Config, w io.Writer) error {
+			return poll(ctx, cfg, w, *interval)
+		}
+	}
 
-// Len ...
-func (dm DomainMap) Len() int {
-	return len(dm.m)
-}
+	// Keep the output file up to date while the long-running modes scan the logs.
+	if cfg.IncrementalOutput && !cfg.NoOutput && (*watchMode || *interval > 0) {
+		cfg.flusher, err = newOutputFlusher(cfg)
+		if err != nil {
+			return fmt.Errorf("unable to start: could not read the output file (%v): %v", cfg.OutputFileName, err)
+		}
+		defer cfg.flusher.close()
+	}
+
+	// When the domains are piped to stdout, everything else goes to stderr so it doesn't end up in the list.
+	w := io.Writer(os.Stdout)
+	if cfg.OutputFileName == stdoutFileName {
+		w = os.Stderr
+	}
 
-// Domains returns the underlying domain map.
-func (dm DomainMap) Domains() map[string]int {
-	dm.l.Lock()
-	defer dm.l.Unlock()
+	err = runner(ctx, cfg, w)
+	// Stopping the signal context also shuts the HTTP server down, if any.
+	stop()
+	if serverDone != nil {
+		<-serverDone
+	}
 
-	return dm.m
+	return err
 }
 
-// DomainsToString returns the gathered domains into a single string, space separated.
-func (dm DomainMap) DomainsToString() string {
-	dm.l.Lock()
+// run executes the whole program with the given config, writing its report to `w`.
+// Cancelling `ctx` lets the files being processed finish, then stops without changing anything.
+// The returned error, if any, is an `*exitError` describing how the program should terminate.
+func run(ctx context.Context, cfg *Config, w io.Writer) error {
+	ts := time.Now()
+	lock := new(sync.Mutex)
 
-	var d strings.Builder
-	for domain, _ := range dm.m {
-		d.WriteString(domain + " ")
+	// The informational report is silenced in quiet mode, the requested output is not.
+	report := w
+	if cfg.Quiet {
+		report = ioutil.Discard
 	}
 
-	dm.l.Unlock()
-	return d.String()
-}
+	bl, err := NewBlacklister(cfg)
+	if err != nil {
+		return &exitError{exitStartupError, fmt.Errorf("unable to start: %v", err)}
+	}
 
-// NewDomainMap returns a pointer to a `DomainMap`.
-func NewDomainMap(l sync.Locker) *DomainMap {
-	return &DomainMap{
-		m: make(map[string]int, 0),
-		l: l,
+	src, err := NewSource(cfg, report)
+	if err != nil {
+		return &exitError{exitStartupError, fmt.Errorf("unable to start: %v", err)}
 	}
-}
 
-// NewConfig reads the JSON config file and returns it as a struct.
-func NewConfig() (*Config, error) {
-	f, err := os.Open("./config.json")
+	// Keep track of all gathered domains.
+	compiledMap := ytblock.NewDomainMap(lock)
+	if cfg.flusher != nil {
+		compiledMap.Notify(cfg.flusher.domains)
+	}
+	var audit *auditTrail
+	if cfg.AuditFile != "" {
+		audit = newAuditTrail()
+	}
+	collected, err := src.Collect(ctx, compiledMap, audit)
 	if err != nil {
-		return nil, fmt.Errorf("config: could not read file: %v", err)
+		return &exitError{exitStartupError, err}
 	}
-	defer f.Close()
+	fileMatches, fileErrors := collected.Files, collected.Errors
 
-	var cfg Config
-	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
-		return nil, fmt.Errorf("config: could not decode file: %v", err)
+	if ctx.Err() != nil {
+		return errInterrupted
 	}
 
-	return &cfg, nil
-}
+	// Without any log file there is nothing to write nor to block.
+	if len(fileMatches) == 0 {
+		if cfg.CountOnly {
+			fmt.Fprintln(os.Stdout, 0)
+		}
+		infof("No log files matched, nothing to do.")
+		return nil
+	}
+
+	// Any file that failed makes the whole run end with an error, once done.
+	var runErr error
+	fmt.Fprintf(report, ">>> Processed (%v) files, (%v) had errors\n", len(fileMatches), len(fileErrors))
+	if collected.Skipped > 0 {
+		fmt.Fprintf(report, ">>> Skipped (%v) unreadable entries of the logs directories\n", collected.Skipped)
+	}
+	if len(fileErrors) > 0 {
+		runErr = &exitError{exitPartialFailure, fmt.Errorf("(%v) of (%v) files could not be processed", len(fileErrors), len(fileMatches))}
+	}
+
+	printFileMatches(report, fileMatches)
 
-func processFile(f string, registry *DomainMap, wg *sync.WaitGroup) error {
-	defer wg.Done()
+	// Drop the domains seen too few times to be worth blocking.
+	compiledMap.Prune(cfg.MinOccurrences)
 
-	openFile, err := os.Open(f)
+	// Never block the whitelisted domains.
+	compiledMap.RemoveMatching(cfg.Whitelist)
+
+	// Work on a single snapshot from here on, so the output file and pihole get exactly the same domains.
+	snapshot := compiledMap.Snapshot()
+	if cfg.CollapseBySN {
+		// The numbered hosts rotate, their `sn-` token doesn't: block it as a whole.
+		snapshot = snapshot.CollapseBySN()
+	}
+	domains := snapshot.DomainList()
+	cfg.status.publish(snapshot)
+
+	totalCollectedDomains := len(domains)
+	cfg.status.scanDone(totalCollectedDomains, time.Since(ts))
+	if cfg.CountOnly {
+		fmt.Fprintln(os.Stdout, totalCollectedDomains)
+		return runErr
+	}
+
+	// Only the domains not blocked by a previous run are sent to pihole.
+	seen, err := loadSeenStore(cfg.SeenStoreFile, cfg.ResetStore)
 	if err != nil {
-		return fmt.Errorf("processFile: skipped unreadable file (%v): %v", f, err)
+		return &exitError{exitStartupError, fmt.Errorf("could not load the seen domains store (%v): %v", cfg.SeenStoreFile, err)}
+	}
+	toBlock := newDomains(domains, seen)
+	if cfg.SeenStoreFile != "" {
+		infof("Found (%v) new domains, skipping (%v) already blocked by previous runs.", len(toBlock), totalCollectedDomains-len(toBlock))
 	}
-	defer openFile.Close()
 
-	var r *bufio.Reader
-	if strings.HasSuffix(f, ".gz") {
-		rr, err := gzip.NewReader(openFile)
-		if err != nil {
+	// With the confirmation dialogue, the files are only written once the user agreed,
+	// so that declining or interrupting it leaves everything untouched.
+	ask := cfg.PopConfirmationDialogue && !cfg.DryRun && len(toBlock) > 0
+	if cfg.NoOutput || ask {
+		fmt.Fprintf(report, ">>> Done: (%v) unique extracted domains in (%v)\n", totalCollectedDomains, time.Since(ts))
+	} else {
+		fmt.Fprintf(report, ">>> Done: (%v) unique extracted domains written to (%v) in (%v)\n",
+			totalCollectedDomains,
+			cfg.OutputFileName,
+			time.Since(ts),
+		)
+	}
+
+	if cfg.PrintDomains && !cfg.DryRun {
+		for _, domain := range domains {
+			fmt.Fprintln(w, domain)
+		}
+	}
+
+	if cfg.Stats > 0 {
+		fmt.Fprintf(w, ">>> Top (%v) domains by hit count:\n", cfg.Stats)
+		for _, dc := range snapshot.TopN(cfg.Stats) {
+			fmt.Fprintf(w, "%8d  %v\n", dc.Count, dc.Domain)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	if cfg.Diff {
+		if err := printDiff(w, cfg, domains); err != nil {
+			return &exitError{exitStartupError, fmt.Errorf("could not read the previous output file (%v): %v", cfg.OutputFileName, err)}
+		}
+	}
+	// Write to a file the gathered domains, along with the audit and the report.
+	writeFiles := func() error {
+		if !cfg.NoOutput {
+			write := func() error {
+				return writeOutput(cfg, snapshot, domains)
+			}
+			var err error
+			if cfg.flusher != nil {
+				err = cfg.flusher.rewrite(domains, write)
+			} else {
+				err = write()
+			}
+			if err != nil {
+				return &exitError{exitStartupError, fmt.Errorf("could not write output to file (%v): %v", cfg.OutputFileName, err)}
+			}
+		}
+		if audit != nil {
+			if err := audit.write(cfg.AuditFile, domains); err != nil {
+				return &exitError{exitStartupError, fmt.Errorf("could not write the audit file (%v): %v", cfg.AuditFile, err)}
+			}
+		}
+		if cfg.ReportFile != "" {
+			rep := newRunReport(ts, fileMatches, len(fileErrors), snapshot)
+			if err := rep.write(cfg.ReportFile); err != nil {
+				return &exitError{exitStartupError, fmt.Errorf("could not write the report file (%v): %v", cfg.ReportFile, err)}
+			}
+		}
+
+		return nil
+	}
+	if !ask {
+		if err := writeFiles(); err != nil {
 			return err
 		}
-		defer rr.Close()
-		r = bufio.NewReader(rr)
-	} else {
-		r = bufio.NewReader(openFile)
 	}
 
-	var lineNumber int
+	// Neither pihole nor the user are bothered when there is nothing to block.
+	if len(toBlock) == 0 {
+		infof("No domains to block.")
+		return runErr
+	}
+
+	// In dry-run mode only show what would have been sent to pihole.
+	// Without an output file, a dry-run only reports the counts.
+	if cfg.DryRun {
+		if !cfg.NoOutput {
+			for _, domain := range toBlock {
+				fmt.Fprintln(w, domain)
+			}
+		}
+
+		infof("dry-run: (%v) domains NOT sent to pihole", len(toBlock))
+		return runErr
+	}
+
+	// Refuse to blast an unexpected flood of domains into pihole.
+	if cfg.Limit > 0 && len(toBlock) > cfg.Limit {
+		return &exitError{exitStartupError, fmt.Errorf("refusing to block (%v) domains, over the limit of (%v): check the patterns with -dry-run, or raise -limit", len(toBlock), cfg.Limit)}
+	}
+
+	block := func() error {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		// Only the domains known to be blocked are marked as seen.
+		blocked := toBlock
+		var verifyErr error
+		switch cfg.BlockMode {
+		case blockModeRegex:
+			patterns, err := cfg.resolvePatterns()
+			if err != nil {
+				return &exitError{exitStartupError, err}
+			}
+			rule, err := piholeRegex(patterns)
+			if err != nil {
+				return &exitError{exitStartupError, err}
+			}
+			if err := bl.BlockRegex(ctx, []string{rule}); err != nil {
+				return &exitError{exitPiholeFailed, fmt.Errorf("could not send `blacklist regex` command to pihole: %v", err)}
+			}
+			infof("Blocked in (%v) mode: sent (1) rule (%v) covering (%v) domains.", cfg.BlockMode, rule, len(toBlock))
+		default:
+			// The wildcards collapsed by `sn-` token can only be blocked as regex rules.
+			var exact, rules []string
+			for _, domain := range toBlock {
+				if strings.Contains(domain, "*") {
+					rules = append(rules, wildcardRegex(domain))
+				} else {
+					exact = append(exact, domain)
+				}
+			}
+			if len(exact) > 0 {
+				if err := bl.Block(ctx, exact); err != nil {
+					return &exitError{exitPiholeFailed, fmt.Errorf("could not send `blacklist domains` command to pihole: %v", err)}
+				}
+			}
+			if len(rules) > 0 {
+				if err := bl.BlockRegex(ctx, rules); err != nil {
+					return &exitError{exitPiholeFailed, fmt.Errorf("could not send `blacklist regex` command to pihole: %v", err)}
+				}
+			}
+			infof("Blocked in (%v) mode: sent (%v) domains and (%v) regex rules.", cfg.BlockMode, len(exact), len(rules))
+			if cfg.VerifyAfterBlock && len(exact) > 0 {
+				missing, err := missingFromBlacklist(ctx, bl, exact)
+				if err != nil {
+					return &exitError{exitPiholeFailed, fmt.Errorf("could not verify the blacklist: %v", err)}
+				}
+				if len(missing) > 0 {
+					warnf("Verification: (%v) of the (%v) domains sent are missing from the blacklist: %v", len(missing), len(exact), strings.Join(missing, " "))
+					// The missing domains are left out of the seen store, so the next run sends them again.
+					notBlocked := make(map[string]struct{}, len(missing))
+					for _, domain := range missing {
+						notBlocked[domain] = struct{}{}
+					}
+					blocked = newDomains(toBlock, notBlocked)
+					verifyErr = &exitError{exitPiholeFailed, fmt.Errorf("(%v) domains sent to pihole did not land on the blacklist", len(missing))}
+				} else {
+					infof("Verification: the (%v) domains sent are all on the blacklist.", len(exact))
+				}
+			}
+		}
+		if err := saveSeenStore(cfg.SeenStoreFile, blocked, cfg.ResetStore); err != nil {
+			return &exitError{exitStartupError, fmt.Errorf("could not update the seen domains store (%v): %v", cfg.SeenStoreFile, err)}
+		}
+		// Once replaced, the store is only added to, e.g. by the next cycles of the daemon.
+		cfg.ResetStore = false
+		if verifyErr != nil {
+			return verifyErr
+		}
+
+		infof("Finished.")
+		return runErr
+	}
+
+	// Directly send the found domains to pihole, if the config says so.
+	if cfg.PopConfirmationDialogue == false {
+		infof("Automatically adding (%v) domains to the blacklist...", len(toBlock))
+		return block()
+	}
+
+	// Otherwise pop up a confirmation dialogue.
+	question := fmt.Sprintf("Would you like to stick those (%v) collected domains into *your* pihole?", len(toBlock))
+	yes, err := confirm(ctx, w, question, cfg.PromptDefault, cfg.promptTimeout())
+	if err != nil {
+		return err
+	}
+	if !yes {
+		infof("No is a no. Bye.")
+		return runErr
+	}
+
+	infof("> Yes. Please wait.")
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	if err := writeFiles(); err != nil {
+		return err
+	}
+	if !cfg.NoOutput {
+		infof("Wrote the (%v) domains to (%v).", totalCollectedDomains, cfg.OutputFileName)
+	}
+	infof("Adding (%v) domains to the blacklist...", len(toBlock))
+	return block()
+}
+
+// newProgress returns a function reporting that `done` of `total` files were processed.
+// Each report is logged, unless `inPlace` is set and stderr is a terminal, in which case
+// a single percentage line is updated in place instead.
+func newProgress(inPlace bool, total int) func(done int) {
+	if !inPlace || !isTerminal(os.Stderr) {
+		return func(done int) {
+			infof("Processed (%v/%v) files.", done, total)
+		}
+	}
 
-LineLoop:
-	for {
-		line, lineTooLong, err := r.ReadLine()
-		switch {
-		case err == io.EOF:
-			break LineLoop
-		case err != nil:
-			log.Printf("Skipped unreadable file (%v): %v", f, err)
-			continue
-		case lineTooLong:
-			log.Printf("Skipped line (%v) in file (%v). Line is too long.", lineNumber, f)
-			continue
+	var mu sync.Mutex
+	return func(done int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fmt.Fprintf(os.Stderr, "\r>>> Progress: %3d%% (%v/%v files)", done*100/total, done, total)
+		if done == total {
+			fmt.Fprintln(os.Stderr)
 		}
+	}
+}
+
+// isTerminal reports whether the file is a terminal rather than a pipe or a regular file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
 
-		for _, m := range rgx.FindAll(line, -1) {
-			s := fmt.Sprintf("%s", m)
-			registry.Insert(s)
+// printFileMatches prints a table of the matches found per file, richest file first.
+func printFileMatches(w io.Writer, fileMatches []FileMatches) {
+	if len(fileMatches) == 0 {
+		return
+	}
+
+	sort.Slice(fileMatches, func(i, j int) bool {
+		if fileMatches[i].Matches != fileMatches[j].Matches {
+			return fileMatches[i].Matches > fileMatches[j].Matches
 		}
+		return fileMatches[i].File < fileMatches[j].File
+	})
 
-		lineNumber++
+	fmt.Fprintln(w, ">>> Matches per file:")
+	for _, fm := range fileMatches {
+		fmt.Fprintf(w, "%8d  %v\n", fm.Matches, fm.File)
 	}
+}
 
-	log.Printf("Finished processing file (%v).", f)
+// readLines returns the non-empty lines read from `r`, without their surrounding whitespace.
+func readLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
 
-	return nil
+	return lines, sc.Err()
 }
 
-func execPihole(s string) ([]byte, error) {
-	var cmd *exec.Cmd
-	cmd = exec.Command("bash", "-c", "pihole -b "+s)
-	return cmd.CombinedOutput()
+// splitList splits a comma separated list, dropping the surrounding whitespace and empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
 }
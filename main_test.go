@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testdataDomains are the video hosts found in the sample logs of `testdata`, sorted.
+var testdataDomains = []string{
+	"r1---sn-4g5e6nsz.googlevideo.com",
+	"r2---sn-4g5e6nsz.googlevideo.com",
+	"r3---sn-n8v7knez.googlevideo.com",
+	"r5---sn-aigl6ned.googlevideo.com",
+	"r7---sn-4g5e6nsz.googlevideo.com",
+}
+
+// recordingExecutor is a fake `Executor` recording every pihole command it is given, instead of running it.
+type recordingExecutor struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (r *recordingExecutor) Exec(ctx context.Context, command, domains []string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, append(append([]string{}, command...), domains...))
+	return nil, nil
+}
+
+// newTestConfig loads a config holding the given options, writing the output file to a temporary directory.
+// The sample logs of `testdata` are scanned unless `PIHOLE_LOGS_DIR` is given.
+func newTestConfig(t *testing.T, options map[string]interface{}) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	settings := map[string]interface{}{
+		"PIHOLE_LOGS_DIR":           "./testdata/",
+		"COMPILED_FILE_NAME":        filepath.Join(dir, "blacklist.txt"),
+		"POP_CONFIRMATION_DIALOGUE": false,
+	}
+	for key, value := range options {
+		settings[key] = value
+	}
+	b, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func TestRunBlocksThroughExecutor(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    [][]string
+	}{
+		{
+			name: "exact",
+			want: [][]string{append([]string{"pihole", "-b"}, testdataDomains...)},
+		},
+		{
+			name:    "custom command and group",
+			options: map[string]interface{}{"PIHOLE_COMMAND": "docker exec pihole pihole", "PIHOLE_GROUP": "kids"},
+			want:    [][]string{append([]string{"docker", "exec", "pihole", "pihole", "-b", "--group", "kids"}, testdataDomains...)},
+		},
+		{
+			name:    "batches",
+			options: map[string]interface{}{"BATCH_SIZE": 2},
+			want: [][]string{
+				append([]string{"pihole", "-b"}, testdataDomains[:2]...),
+				append([]string{"pihole", "-b"}, testdataDomains[2:4]...),
+				append([]string{"pihole", "-b"}, testdataDomains[4:]...),
+			},
+		},
+		{
+			name:    "whitelist",
+			options: map[string]interface{}{"WHITELIST": []string{"*.googlevideo.com"}},
+			want:    nil,
+		},
+		{
+			name:    "collapsed",
+			options: map[string]interface{}{"COLLAPSE_BY_SN": true},
+			want: [][]string{{"pihole", "--regex",
+				`^[^.]*sn-4g5e6nsz\.googlevideo\.com$`,
+				`^[^.]*sn-aigl6ned\.googlevideo\.com$`,
+				`^[^.]*sn-n8v7knez\.googlevideo\.com$`,
+			}},
+		},
+		{
+			name:    "dry-run",
+			options: map[string]interface{}{"DRY_RUN": true},
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, tt.options)
+			ex := new(recordingExecutor)
+			cfg.executor = ex
+
+			if err := run(context.Background(), cfg, new(bytes.Buffer)); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if !reflect.DeepEqual(ex.calls, tt.want) {
+				t.Errorf("got pihole commands\n%v\nwant\n%v", strings.Join(joinCalls(ex.calls), "\n"), strings.Join(joinCalls(tt.want), "\n"))
+			}
+		})
+	}
+}
+
+// joinCalls returns the recorded commands as command lines, for readable failures.
+func joinCalls(calls [][]string) []string {
+	lines := make([]string, len(calls))
+	for i, call := range calls {
+		lines[i] = strings.Join(call, " ")
+	}
+
+	return lines
+}
+
+func TestRunSeveralLogsDirectories(t *testing.T) {
+	dir := t.TempDir()
+	log := "Jan  3 10:00:00 dnsmasq[1]: query[A] r9---sn-other.googlevideo.com from 192.168.1.50\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "pihole.log"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newTestConfig(t, map[string]interface{}{"PIHOLE_LOGS_DIRS": []string{dir}})
+	ex := new(recordingExecutor)
+	cfg.executor = ex
+
+	if err := run(context.Background(), cfg, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	domains := append(append([]string{}, testdataDomains...), "r9---sn-other.googlevideo.com")
+	sort.Strings(domains)
+	if want := [][]string{append([]string{"pihole", "-b"}, domains...)}; !reflect.DeepEqual(ex.calls, want) {
+		t.Errorf("got pihole commands %v, want %v", joinCalls(ex.calls), joinCalls(want))
+	}
+}
+
+func TestRunNoLogFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+	}{
+		{"empty directory", nil},
+		{"other files only", []string{"dnsmasq.log", "FTL.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("query[A] r1---sn-abc.googlevideo.com\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := newTestConfig(t, map[string]interface{}{"PIHOLE_LOGS_DIR": dir})
+			ex := new(recordingExecutor)
+			cfg.executor = ex
+
+			if err := run(context.Background(), cfg, new(bytes.Buffer)); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if len(ex.calls) > 0 {
+				t.Errorf("got pihole commands %v, want none", joinCalls(ex.calls))
+			}
+			if _, err := os.Stat(cfg.OutputFileName); !os.IsNotExist(err) {
+				t.Errorf("got an output file (%v) without any log, want none: %v", cfg.OutputFileName, err)
+			}
+		})
+	}
+}
+
+func TestRunNoDomains(t *testing.T) {
+	dir := t.TempDir()
+	log := "Jan  2 15:04:07 dnsmasq[512]: query[A] www.youtube.com from 192.168.1.20\n" +
+		"Jan  2 15:04:09 dnsmasq[512]: query[A] example.com from 192.168.1.31\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "pihole.log"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newTestConfig(t, map[string]interface{}{"PIHOLE_LOGS_DIR": dir, "POP_CONFIRMATION_DIALOGUE": true})
+	ex := new(recordingExecutor)
+	cfg.executor = ex
+
+	// Nothing is asked either: the confirmation would otherwise wait for an answer on stdin.
+	if err := run(context.Background(), cfg, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(ex.calls) > 0 {
+		t.Errorf("got pihole commands %v, want none", joinCalls(ex.calls))
+	}
+}
+
+// TestRunPiholeCommand runs a stub pihole binary given by its path, recording the arguments it receives.
+func TestRunPiholeCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub pihole is a shell script")
+	}
+	dir := t.TempDir()
+	stub := filepath.Join(dir, "pihole-stub")
+	script := "#!/bin/sh\necho \"$@\" >> \"$0.args\"\n"
+	if err := ioutil.WriteFile(stub, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newTestConfig(t, map[string]interface{}{"PIHOLE_COMMAND": stub, "BATCH_SIZE": 3})
+
+	if err := run(context.Background(), cfg, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	b, err := ioutil.ReadFile(stub + ".args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-b " + strings.Join(testdataDomains[:3], " ") + "\n" + "-b " + strings.Join(testdataDomains[3:], " ") + "\n"
+	if string(b) != want {
+		t.Errorf("got arguments\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestRunRegexMode(t *testing.T) {
+	cfg := newTestConfig(t, map[string]interface{}{"BLOCK_MODE": blockModeRegex, "MATCH_PATTERNS": []string{`\br[0-9]+---sn-[a-z0-9-]+\.googlevideo\.com\b`}})
+	ex := new(recordingExecutor)
+	cfg.executor = ex
+
+	if err := run(context.Background(), cfg, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := [][]string{{"pihole", "--regex", `(^|[.-])r[0-9]+---sn-[a-z0-9-]+\.googlevideo\.com($|[.-])`}}
+	if !reflect.DeepEqual(ex.calls, want) {
+		t.Errorf("got pihole commands %v, want %v", joinCalls(ex.calls), joinCalls(want))
+	}
+}
+
+// TestRunRegexModeUnconvertible checks a pattern pihole cannot run never turns into an empty rule, matching every domain.
+func TestRunRegexModeUnconvertible(t *testing.T) {
+	cfg := newTestConfig(t, map[string]interface{}{"BLOCK_MODE": blockModeRegex})
+	// As set by `-pattern`, after the config was loaded.
+	cfg.MatchPatterns = []string{`r[0-9]+\b---sn-[a-z0-9-]+\.googlevideo\.com`}
+	if err := cfg.compileMatchers(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("got no validation error for an inner word boundary in regex mode")
+	}
+	ex := new(recordingExecutor)
+	cfg.executor = ex
+
+	if err := run(context.Background(), cfg, new(bytes.Buffer)); exitCode(err) != exitStartupError {
+		t.Errorf("got error (%v), want a startup error", err)
+	}
+	if len(ex.calls) > 0 {
+		t.Errorf("got pihole commands %v, want none", joinCalls(ex.calls))
+	}
+}
+
+// TestRunConfirmationWritesNothing checks declining or interrupting the confirmation dialogue leaves the files untouched.
+func TestRunConfirmationWritesNothing(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   int
+	}{
+		{"declined", "n\n", exitOK},
+		{"interrupted", "", exitInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := newTestConfig(t, map[string]interface{}{
+				"POP_CONFIRMATION_DIALOGUE": true,
+				"REPORT_FILE":               filepath.Join(dir, "report.json"),
+			})
+			cfg.AuditFile = filepath.Join(dir, "audit.csv")
+			ex := new(recordingExecutor)
+			cfg.executor = ex
+
+			// The dialogue reads its answer from stdin.
+			r, pw, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			defer pw.Close()
+			stdin := os.Stdin
+			os.Stdin = r
+			defer func() { os.Stdin = stdin }()
+			if _, err := pw.WriteString(tt.answer); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.answer == "" {
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+			if err := run(ctx, cfg, new(bytes.Buffer)); exitCode(err) != tt.want {
+				t.Errorf("got error (%v), want exit code (%v)", err, tt.want)
+			}
+			if len(ex.calls) > 0 {
+				t.Errorf("got pihole commands %v, want none", joinCalls(ex.calls))
+			}
+			for _, path := range []string{cfg.OutputFileName, cfg.AuditFile, cfg.ReportFile} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("got file (%v) written, want none: %v", path, err)
+				}
+			}
+		})
+	}
+}
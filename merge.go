@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// merge writes to the configured output file the union of the domains of the given list files,
+// e.g. the output files of several piholes, without scanning any log.
+// The lists hold one domain per line, plain or in the hosts file format, and are decompressed when ending in `.gz`.
+// Every domain is counted once per list it appears in; the whitelisted ones are left out.
+func merge(ctx context.Context, cfg *Config, w io.Writer, lists []string) error {
+	if len(lists) == 0 {
+		return &exitError{exitStartupError, fmt.Errorf("-merge requires the list files to merge as arguments")}
+	}
+
+	merged := ytblock.NewDomainMap(new(sync.Mutex))
+	for _, path := range lists {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		b, err := readOutputFile(path)
+		if err != nil {
+			return &exitError{exitStartupError, fmt.Errorf("could not read domains from file (%v): %v", path, err)}
+		}
+		domains := parseDomains(b)
+		for domain := range domains {
+			merged.Insert(domain)
+		}
+		infof("Read (%v) domains from file (%v).", len(domains), path)
+	}
+	merged.RemoveMatching(cfg.Whitelist)
+	domains := merged.DomainList()
+
+	if cfg.Diff {
+		if err := printDiff(w, cfg, domains); err != nil {
+			return &exitError{exitStartupError, fmt.Errorf("could not read the previous output file (%v): %v", cfg.OutputFileName, err)}
+		}
+	}
+	if err := writeOutput(cfg, merged, domains); err != nil {
+		return &exitError{exitStartupError, fmt.Errorf("could not write output to file (%v): %v", cfg.OutputFileName, err)}
+	}
+
+	infof("Merged (%v) unique domains from (%v) files into (%v).", len(domains), len(lists), cfg.OutputFileName)
+	return nil
+}
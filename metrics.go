@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// scanDurationBuckets are the upper bounds, in seconds, of the scan duration histogram.
+var scanDurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// metrics counts what the scans did, exposed in the Prometheus text format.
+type metrics struct {
+	mu             sync.Mutex
+	domains        int
+	filesProcessed int
+	filesErrored   int
+	scanBuckets    []int
+	scanCount      int
+	scanSum        float64
+}
+
+// fileProcessed counts a processed log file, and whether it failed.
+func (m *metrics) fileProcessed(failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.filesProcessed++
+	if failed {
+		m.filesErrored++
+	}
+}
+
+// scanDone counts the domains collected by a scan and observes how long it took.
+func (m *metrics) scanDone(domains int, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.scanBuckets == nil {
+		m.scanBuckets = make([]int, len(scanDurationBuckets))
+	}
+	secs := took.Seconds()
+	for i, le := range scanDurationBuckets {
+		if secs <= le {
+			m.scanBuckets[i]++
+		}
+	}
+	m.scanCount++
+	m.scanSum += secs
+	m.domains += domains
+}
+
+// writeTo writes every metric to `w` in the Prometheus text exposition format.
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counter := func(name, help string, v int) {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n%v %v\n", name, help, name, name, v)
+	}
+	counter("pihole_yt_domains_total", "Unique domains collected, summed over all scans.", m.domains)
+	counter("pihole_yt_files_processed_total", "Log files processed.", m.filesProcessed)
+	counter("pihole_yt_files_errored_total", "Log files that could not be processed.", m.filesErrored)
+
+	const name = "pihole_yt_scan_duration_seconds"
+	fmt.Fprintf(w, "# HELP %v How long a whole scan took.\n# TYPE %v histogram\n", name, name)
+	for i, le := range scanDurationBuckets {
+		n := 0
+		if m.scanBuckets != nil {
+			n = m.scanBuckets[i]
+		}
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", name, le, n)
+	}
+	fmt.Fprintf(w, "%v_bucket{le=\"+Inf\"} %v\n", name, m.scanCount)
+	fmt.Fprintf(w, "%v_sum %v\n", name, m.scanSum)
+	fmt.Fprintf(w, "%v_count %v\n", name, m.scanCount)
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.writeTo(w)
+}
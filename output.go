@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// Supported values for the `OUTPUT_FORMAT` config option.
+const (
+	outputFormatTXT  = "txt"
+	outputFormatJSON = "json"
+	outputFormatCSV  = "csv"
+)
+
+// Supported values for the `OUTPUT_STYLE` config option, shaping the lines of the txt output format.
+const (
+	outputStylePlain = "plain"
+	outputStyleHosts = "hosts"
+)
+
+// hostsAddress is the address every domain of a hosts style output resolves to.
+const hostsAddress = "0.0.0.0"
+
+// stdoutFileName is the `COMPILED_FILE_NAME` writing the domains to standard output instead of a file.
+const stdoutFileName = "-"
+
+// writeOutput writes the gathered domains to the configured output file, in the configured format.
+// `domains` is the sorted list of the domains of `dm`, so the caller can reuse the exact same list.
+func writeOutput(cfg *Config, dm *ytblock.DomainMap, domains []string) error {
+	path := outputPath(cfg.OutputFileName)
+	write := func(b []byte) error {
+		if strings.HasSuffix(path, gzipSuffix) {
+			var err error
+			if b, err = gzipBytes(b); err != nil {
+				return err
+			}
+		}
+		// Leave the file, and its modification time, untouched when nothing changed.
+		if unchanged(path, b) {
+			infof("No changes to the output file (%v), not rewritten.", path)
+			return nil
+		}
+		return writeFileAtomic(path, b)
+	}
+	if cfg.OutputFileName == stdoutFileName {
+		write = func(b []byte) error {
+			_, err := os.Stdout.Write(b)
+			return err
+		}
+	}
+
+	switch cfg.OutputFormat {
+	case outputFormatJSON:
+		b, err := json.MarshalIndent(dm, "", "  ")
+		if err != nil {
+			return err
+		}
+		return write(append(b, '\n'))
+	case outputFormatCSV:
+		b, err := domainsCSV(dm.Counts())
+		if err != nil {
+			return err
+		}
+		return write(b)
+	case outputFormatTXT:
+		if cfg.AppendOutput {
+			previous, err := readOutputFile(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			domains = mergeDomains(previous, domains)
+		}
+		if cfg.OutputStyle == outputStyleHosts {
+			return write(hostsText(domains))
+		}
+		return write(domainsText(domains))
+	default:
+		return fmt.Errorf("unknown output format (%v)", cfg.OutputFormat)
+	}
+}
+
+// readOutput reads back the domains from the configured output file, in the configured format.
+func readOutput(cfg *Config) ([]string, error) {
+	if cfg.OutputFileName == stdoutFileName {
+		return nil, fmt.Errorf("the domains were written to stdout, give the list files to read instead")
+	}
+
+	b, err := readOutputFile(outputPath(cfg.OutputFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.OutputFormat {
+	case outputFormatJSON:
+		dm := ytblock.NewDomainMap(new(sync.Mutex))
+		if err := json.Unmarshal(b, dm); err != nil {
+			return nil, err
+		}
+		return dm.DomainList(), nil
+	case outputFormatCSV:
+		records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		domains := make(map[string]struct{})
+		for i, record := range records {
+			if i == 0 || len(record) == 0 {
+				continue
+			}
+			domains[record[0]] = struct{}{}
+		}
+		return ytblock.SortedDomains(domains), nil
+	default:
+		return ytblock.SortedDomains(parseDomains(b)), nil
+	}
+}
+
+// printDiff prints to `w` the domains added and removed since the previous output file,
+// which counts as empty when missing. Both `domains` and the previous ones are sorted.
+func printDiff(w io.Writer, cfg *Config, domains []string) error {
+	previous, err := readOutput(cfg)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Appended to, the output file is to hold the previous domains along with the new ones.
+	if cfg.AppendOutput {
+		domains = mergeDomains(domainsText(previous), domains)
+	}
+	added, removed := diffDomains(previous, domains)
+	fmt.Fprintf(w, ">>> Added (%v) domains since the previous output:\n", len(added))
+	for _, domain := range added {
+		fmt.Fprintf(w, "+ %v\n", domain)
+	}
+	fmt.Fprintf(w, ">>> Removed (%v) domains since the previous output:\n", len(removed))
+	for _, domain := range removed {
+		fmt.Fprintf(w, "- %v\n", domain)
+	}
+
+	return nil
+}
+
+// diffDomains returns the domains of `current` missing from `previous`, and those of `previous` missing from `current`.
+// Both lists must be sorted; so are the results.
+func diffDomains(previous, current []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(previous) || j < len(current) {
+		switch {
+		case j == len(current) || (i < len(previous) && previous[i] < current[j]):
+			removed = append(removed, previous[i])
+			i++
+		case i == len(previous) || current[j] < previous[i]:
+			added = append(added, current[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+
+	return added, removed
+}
+
+// domainsCSV encodes the domains as CSV with a `domain,count` header row.
+// Quoting of unusual values is left to the csv writer.
+func domainsCSV(counts []ytblock.DomainCount) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.Write([]string{"domain", "count"}); err != nil {
+		return nil, err
+	}
+	for _, dc := range counts {
+		if err := w.Write([]string{dc.Domain, strconv.Itoa(dc.Count)}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+
+	return b.Bytes(), w.Error()
+}
+
+// writeDomainsFile writes the domains to the file found at `path`, one per line, sorted.
+// The file is overwritten unless `appendMode` is set, in which case the domains
+// already present in the file are kept and merged with the new ones.
+func writeDomainsFile(path string, domains []string, appendMode bool) error {
+	if appendMode {
+		previous, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		domains = mergeDomains(previous, domains)
+	}
+
+	return writeFileAtomic(path, domainsText(domains))
+}
+
+// mergeDomains returns the domains found in `previous`, one per line, merged with `domains`, sorted.
+func mergeDomains(previous []byte, domains []string) []string {
+	merged := parseDomains(previous)
+	for _, domain := range domains {
+		merged[domain] = struct{}{}
+	}
+
+	return ytblock.SortedDomains(merged)
+}
+
+// gzipSuffix marks the output files written, and read back, gzip compressed.
+const gzipSuffix = ".gz"
+
+// gzipBytes returns `b` gzip compressed.
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	// Closing flushes the pending data and writes the gzip footer, without which the file is truncated.
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readOutputFile reads the output file found at `path`, decompressing it when its name ends in `.gz`.
+func readOutputFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil || !strings.HasSuffix(path, gzipSuffix) {
+		return b, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+// domainsText returns the domains one per line.
+func domainsText(domains []string) []byte {
+	var b bytes.Buffer
+	for _, domain := range domains {
+		b.WriteString(domain + "\n")
+	}
+
+	return b.Bytes()
+}
+
+// hostsText returns the domains one per line in the hosts file format, e.g. `0.0.0.0 example.com`,
+// so the file can be used as a pihole adlist.
+func hostsText(domains []string) []byte {
+	var b bytes.Buffer
+	for _, domain := range domains {
+		b.WriteString(hostsAddress + " " + domain + "\n")
+	}
+
+	return b.Bytes()
+}
+
+// writeFileAtomic writes the content to a temporary file in the same directory as `path`
+// which then replaces the target, so the previous file is left intact if anything goes wrong.
+// The permissions of the replaced file are kept, and a symlink is written through, not replaced.
+func writeFileAtomic(path string, content []byte) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(content); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	// The content must be on disk before the rename makes it the file, or a crash could leave it empty.
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// unchanged reports whether the file found at `path` already holds exactly `content`.
+// A file that cannot be read is reported as changed.
+func unchanged(path string, content []byte) bool {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	return sha256.Sum256(b) == sha256.Sum256(content)
+}
+
+// parseDomains returns the set of domains found in `b`, one per line, either alone or in the hosts file format.
+// Empty lines and `#` comments are ignored.
+func parseDomains(b []byte) map[string]struct{} {
+	domains := make(map[string]struct{})
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// The domain comes last, after the address of a hosts file line.
+		domains[fields[len(fields)-1]] = struct{}{}
+	}
+
+	return domains
+}
+
+// outputPath returns the path the output file is written to.
+// Names containing a path separator are used verbatim, bare file names are placed in the current directory.
+func outputPath(name string) string {
+	if strings.ContainsAny(name, "/"+string(os.PathSeparator)) {
+		return name
+	}
+
+	return "./" + name
+}
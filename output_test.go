@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"blocked_domains.txt", "./blocked_domains.txt"},
+		{"./blocked_domains.txt", "./blocked_domains.txt"},
+		{"out/blocked_domains.txt", "out/blocked_domains.txt"},
+		{"../blocked_domains.txt", "../blocked_domains.txt"},
+		{"/etc/pihole/blocked_domains.txt", "/etc/pihole/blocked_domains.txt"},
+	}
+	for _, tt := range tests {
+		if got := outputPath(tt.name); got != tt.want {
+			t.Errorf("outputPath(%q): got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDomainsCSV(t *testing.T) {
+	counts := []ytblock.DomainCount{
+		{Domain: "r1---sn-abc.googlevideo.com", Count: 12},
+		{Domain: "odd,domain\"", Count: 1},
+	}
+	b, err := domainsCSV(counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		t.Fatalf("could not read the CSV back: %v\n%s", err, b)
+	}
+	want := [][]string{
+		{"domain", "count"},
+		{"r1---sn-abc.googlevideo.com", "12"},
+		{"odd,domain\"", "1"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got records %v, want %v", records, want)
+	}
+}
+
+func TestReadOutputFileCompressed(t *testing.T) {
+	dir := t.TempDir()
+	content := domainsText([]string{"a.googlevideo.com", "b.googlevideo.com"})
+	compressed, err := gzipBytes(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		file  string
+		bytes []byte
+	}{
+		{"compressed", "blacklist.txt.gz", compressed},
+		{"plain", "blacklist.txt", content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(path, tt.bytes, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readOutputFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("got %q, want %q", got, content)
+			}
+		})
+	}
+}
+
+func TestRunCompressedOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blacklist.txt.gz")
+	cfg := newTestConfig(t, map[string]interface{}{"COMPILED_FILE_NAME": path, "DRY_RUN": true})
+
+	if err := run(context.Background(), cfg, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) {
+		t.Errorf("got an uncompressed output file (%v)", path)
+	}
+	got, err := readOutputFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := domainsText(testdataDomains); !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blacklist.txt")
+	if err := writeFileAtomic(path, []byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0644 {
+		t.Fatalf("got a new file (%v), want mode 0644: %v", fi, err)
+	}
+
+	// The permissions of an existing file are kept.
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("got file (%v), want its mode 0600 kept: %v", fi, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on windows")
+	}
+	// A symlink is written through: the link stays, its target gets the content.
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(link, []byte("c\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("got (%v) replacing the symlink, want it kept: %v", fi, err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "c\n" {
+		t.Errorf("got target content %q, want %q: %v", b, "c\n", err)
+	}
+}
+
+func TestPrintDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    string
+	}{
+		{
+			name: "replaced",
+			want: ">>> Added (1) domains since the previous output:\n+ c.googlevideo.com\n" +
+				">>> Removed (1) domains since the previous output:\n- a.googlevideo.com\n",
+		},
+		{
+			name:    "appended",
+			options: map[string]interface{}{"APPEND_OUTPUT": true},
+			want: ">>> Added (1) domains since the previous output:\n+ c.googlevideo.com\n" +
+				">>> Removed (0) domains since the previous output:\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, tt.options)
+			if err := ioutil.WriteFile(cfg.OutputFileName, domainsText([]string{"a.googlevideo.com", "b.googlevideo.com"}), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			if err := printDiff(&b, cfg, []string{"b.googlevideo.com", "c.googlevideo.com"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got diff\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
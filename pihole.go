@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Supported values for the `PIHOLE_BACKEND` config option.
+const (
+	backendCLI = "cli"
+	backendAPI = "api"
+)
+
+// Supported values for the `BLOCK_MODE` config option.
+const (
+	blockModeExact = "exact"
+	blockModeRegex = "regex"
+)
+
+// retryBackoff is the wait before the first retry of a failed pihole command, doubled on every further retry.
+const retryBackoff = time.Second
+
+// Flags of the `pihole` command selecting the list domains are added to.
+const (
+	listBlack = "-b"
+	listWhite = "-w"
+	listRegex = "--regex"
+)
+
+// Blacklister adds domains, or regex rules, to the pihole blacklist, or releases domains through the whitelist.
+type Blacklister interface {
+	Block(ctx context.Context, domains []string) error
+	BlockRegex(ctx context.Context, rules []string) error
+	Whitelist(ctx context.Context, domains []string) error
+	Blacklisted(ctx context.Context) ([]string, error)
+}
+
+// NewBlacklister returns the `Blacklister` selected by the configured backend.
+func NewBlacklister(cfg *Config) (Blacklister, error) {
+	switch cfg.PiholeBackend {
+	case backendCLI:
+		ex := cfg.executor
+		if ex == nil {
+			ex = commandExecutor{}
+		}
+		return &cliBlacklister{
+			executor:   ex,
+			command:    strings.Fields(cfg.PiholeCommand),
+			batchSize:  cfg.BatchSize,
+			batchDelay: cfg.batchDelay(),
+			retries:    *cfg.PiholeRetries,
+			timeout:    cfg.piholeTimeout(),
+			group:      cfg.PiholeGroup,
+		}, nil
+	case backendAPI:
+		if cfg.PiholeAPIURL == "" {
+			return nil, fmt.Errorf("blacklister: PIHOLE_API_URL is required for the (%v) backend", backendAPI)
+		}
+		transport, err := cfg.apiTransport()
+		if err != nil {
+			return nil, fmt.Errorf("blacklister: %v", err)
+		}
+		return &apiBlacklister{
+			baseURL:    strings.TrimRight(cfg.PiholeAPIURL, "/"),
+			token:      cfg.PiholeAPIToken,
+			batchSize:  cfg.BatchSize,
+			batchDelay: cfg.batchDelay(),
+			group:      cfg.PiholeGroup,
+			client:     &http.Client{Timeout: cfg.piholeTimeout(), Transport: transport},
+		}, nil
+	default:
+		return nil, fmt.Errorf("blacklister: unknown backend (%v), use (%v) or (%v)", cfg.PiholeBackend, backendCLI, backendAPI)
+	}
+}
+
+// Executor runs a pihole command, followed by the domains as separate arguments, and returns its combined output.
+// The real one runs the command; a fake recording the domains it receives lets the whole flow run without a pihole.
+type Executor interface {
+	Exec(ctx context.Context, command, domains []string) ([]byte, error)
+}
+
+// commandExecutor is the real `Executor`, running the commands without a shell.
+type commandExecutor struct{}
+
+// Exec runs the command until it exits or `ctx` is cancelled.
+func (commandExecutor) Exec(ctx context.Context, command, domains []string) ([]byte, error) {
+	args := append(append([]string{}, command[1:]...), domains...)
+	return exec.CommandContext(ctx, command[0], args...).CombinedOutput()
+}
+
+// cliBlacklister blocks domains by running the `pihole` command.
+type cliBlacklister struct {
+	executor Executor
+	// command runs pihole, e.g. `pihole` or `docker exec pihole pihole`, split into its arguments.
+	command    []string
+	batchSize  int
+	batchDelay time.Duration
+	retries    int
+	timeout    time.Duration
+	group      string
+}
+
+// Block sends the domains to the `pihole -b` command, in batches.
+func (c *cliBlacklister) Block(ctx context.Context, domains []string) error {
+	return c.send(ctx, listBlack, domains)
+}
+
+// BlockRegex sends the rules to the `pihole --regex` command, in batches.
+func (c *cliBlacklister) BlockRegex(ctx context.Context, rules []string) error {
+	return c.send(ctx, listRegex, rules)
+}
+
+// apiTransport returns the transport of the API calls, going through `HTTP_PROXY_URL` when set,
+// or else through the proxy of the standard `HTTP_PROXY`, `HTTPS_PROXY` and `NO_PROXY` environment variables, if any.
+// The certificate of the pihole is checked against `PIHOLE_CA_FILE` too, if set.
+func (cfg *Config) apiTransport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := cfg.apiTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+
+	if cfg.HTTPProxyURL == "" {
+		t.Proxy = http.ProxyFromEnvironment
+		return t, nil
+	}
+
+	u, err := parseProxyURL(cfg.HTTPProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	t.Proxy = http.ProxyURL(u)
+
+	return t, nil
+}
+
+// apiTLSConfig returns the TLS config of the API calls, trusting the system roots
+// along with the certificates of `PIHOLE_CA_FILE`, or nothing at all with `PIHOLE_INSECURE_SKIP_VERIFY`.
+func (cfg *Config) apiTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.PiholeSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+		return tlsConfig, nil
+	}
+	if cfg.PiholeCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(cfg.PiholeCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read PIHOLE_CA_FILE (%v): %v", cfg.PiholeCAFile, err)
+	}
+	// Without the system roots, e.g. on a minimal container, the custom CA is trusted alone.
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificate found in PIHOLE_CA_FILE (%v)", cfg.PiholeCAFile)
+	}
+	tlsConfig.RootCAs = pool
+
+	return tlsConfig, nil
+}
+
+// parseProxyURL parses an `HTTP_PROXY_URL`, which must be an http, https or socks5 URL with a host.
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_URL (%v): %v", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid HTTP_PROXY_URL (%v): unsupported scheme (%v), use http, https or socks5", raw, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_URL (%v): missing host", raw)
+	}
+
+	return u, nil
+}
+
+// Whitelist sends the domains to the `pihole -w` command, in batches.
+func (c *cliBlacklister) Whitelist(ctx context.Context, domains []string) error {
+	return c.send(ctx, listWhite, domains)
+}
+
+// Blacklisted returns the domains of the exact blacklist, as listed by `pihole -b -l`.
+func (c *cliBlacklister) Blacklisted(ctx context.Context) ([]string, error) {
+	command := append(append([]string{}, c.command...), listBlack, "-l")
+	out, err := execPiholeRetry(ctx, c.executor, c.retries, c.timeout, command, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the blacklist: %v: %s", err, bytes.TrimSpace(out))
+	}
+
+	return parseBlacklist(out), nil
+}
+
+// listedDomain matches a word of `pihole -b -l` looking like a domain: dot separated labels, the last one with a letter,
+// e.g. `example.com` but neither `1:` nor `2024-01-01` nor an IP address.
+var listedDomain = regexp.MustCompile(`^(?i)([a-z0-9_-]+\.)+[a-z0-9-]*[a-z][a-z0-9-]*\.?$`)
+
+// parseBlacklist returns the domains listed in the output of `pihole -b -l`, one per line at most:
+// the first word of every line looking like a domain, stripped of the punctuation around it.
+// Numbering, dates, comments and decorations are ignored, so the entries are found whatever the pihole version
+// formats them like, e.g. `  1: example.com (added 2024-01-01)` or `| example.com | enabled |`.
+func parseBlacklist(out []byte) []string {
+	var domains []string
+	for _, line := range strings.Split(string(out), "\n") {
+		for _, field := range strings.Fields(line) {
+			field = strings.Trim(field, "\"'`()[]{}<>,;:|*")
+			if listedDomain.MatchString(field) {
+				domains = append(domains, strings.TrimSuffix(field, "."))
+				break
+			}
+		}
+	}
+
+	return domains
+}
+
+// send adds the domains to the given list, and group if any, and logs the output of pihole.
+func (c *cliBlacklister) send(ctx context.Context, list string, domains []string) error {
+	command := append(append([]string{}, c.command...), list)
+	if c.group != "" {
+		command = append(command, "--group", c.group)
+	}
+
+	out, err := blacklist(ctx, c.executor, command, domains, c.batchSize, c.batchDelay, c.retries, c.timeout)
+	if len(out) > 0 {
+		infof("Output from pihole: %s", out)
+	}
+
+	return err
+}
+
+// apiBlacklister blocks domains through the Pi-hole v6 REST API.
+type apiBlacklister struct {
+	baseURL    string
+	token      string
+	batchSize  int
+	batchDelay time.Duration
+	group      string
+	client     *http.Client
+}
+
+// Block authenticates against the API and adds the domains to the exact deny list, in batches.
+func (a *apiBlacklister) Block(ctx context.Context, domains []string) error {
+	return a.send(ctx, "/api/domains/deny/exact", domains)
+}
+
+// BlockRegex authenticates against the API and adds the rules to the regex deny list, in batches.
+func (a *apiBlacklister) BlockRegex(ctx context.Context, rules []string) error {
+	return a.send(ctx, "/api/domains/deny/regex", rules)
+}
+
+// Whitelist authenticates against the API and adds the domains to the exact allow list, in batches.
+func (a *apiBlacklister) Whitelist(ctx context.Context, domains []string) error {
+	return a.send(ctx, "/api/domains/allow/exact", domains)
+}
+
+// Blacklisted authenticates against the API and returns the domains of the exact deny list.
+func (a *apiBlacklister) Blacklisted(ctx context.Context) ([]string, error) {
+	sid, err := a.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.logout(ctx, sid)
+
+	var resp struct {
+		Domains []struct {
+			Domain string `json:"domain"`
+		} `json:"domains"`
+	}
+	if err := a.do(ctx, http.MethodGet, "/api/domains/deny/exact", sid, nil, &resp); err != nil {
+		return nil, fmt.Errorf("api: could not list the deny list: %v", err)
+	}
+	domains := make([]string, 0, len(resp.Domains))
+	for _, d := range resp.Domains {
+		domains = append(domains, d.Domain)
+	}
+
+	return domains, nil
+}
+
+// missingFromBlacklist returns the domains that are not on the pihole blacklist,
+// e.g. when pihole accepted the command but added nothing.
+func missingFromBlacklist(ctx context.Context, bl Blacklister, domains []string) ([]string, error) {
+	listed, err := bl.Blacklisted(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]struct{}, len(listed))
+	for _, domain := range listed {
+		set[strings.ToLower(domain)] = struct{}{}
+	}
+	var missing []string
+	for _, domain := range domains {
+		if _, ok := set[domain]; !ok {
+			missing = append(missing, domain)
+		}
+	}
+
+	return missing, nil
+}
+
+// send adds the domains to the list found at the API `path`, assigned to the configured group if any.
+func (a *apiBlacklister) send(ctx context.Context, path string, domains []string) error {
+	sid, err := a.login(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.logout(ctx, sid)
+
+	var groups []int
+	if a.group != "" {
+		id, err := a.groupID(ctx, sid)
+		if err != nil {
+			return err
+		}
+		groups = []int{id}
+	}
+
+	batches := batchDomains(domains, a.batchSize)
+	for i, batch := range batches {
+		if err := pauseBetweenBatches(ctx, i, a.batchDelay); err != nil {
+			return fmt.Errorf("batch (%v/%v) not sent: %v", i+1, len(batches), err)
+		}
+		body := map[string]interface{}{
+			"domain":  batch,
+			"comment": "added by pihole-youtube-block",
+			"enabled": true,
+		}
+		if groups != nil {
+			body["groups"] = groups
+		}
+		if err := a.do(ctx, http.MethodPost, path, sid, body, nil); err != nil {
+			return fmt.Errorf("batch (%v/%v) failed: %v", i+1, len(batches), err)
+		}
+	}
+
+	return nil
+}
+
+// groupID returns the id of the configured group, failing when no group has that name.
+func (a *apiBlacklister) groupID(ctx context.Context, sid string) (int, error) {
+	var resp struct {
+		Groups []struct {
+			Name string `json:"name"`
+			ID   int    `json:"id"`
+		} `json:"groups"`
+	}
+	if err := a.do(ctx, http.MethodGet, "/api/groups", sid, nil, &resp); err != nil {
+		return 0, fmt.Errorf("api: could not list the groups: %v", err)
+	}
+	for _, g := range resp.Groups {
+		if g.Name == a.group {
+			return g.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("api: group (%v) does not exist", a.group)
+}
+
+// login exchanges the configured token for a session id.
+func (a *apiBlacklister) login(ctx context.Context) (string, error) {
+	var resp struct {
+		Session struct {
+			Valid bool   `json:"valid"`
+			SID   string `json:"sid"`
+		} `json:"session"`
+	}
+	if err := a.do(ctx, http.MethodPost, "/api/auth", "", map[string]string{"password": a.token}, &resp); err != nil {
+		return "", fmt.Errorf("api: could not authenticate: %v", err)
+	}
+	if !resp.Session.Valid {
+		return "", fmt.Errorf("api: could not authenticate: session is not valid")
+	}
+
+	return resp.Session.SID, nil
+}
+
+// logout ends the session. Failing to do so is not fatal, the session expires on its own.
+func (a *apiBlacklister) logout(ctx context.Context, sid string) {
+	if sid == "" {
+		return
+	}
+	if err := a.do(ctx, http.MethodDelete, "/api/auth", sid, nil, nil); err != nil {
+		warnf("api: could not end the session: %v", err)
+	}
+}
+
+// do sends a JSON request to the API and decodes the JSON response into `out`, if given.
+func (a *apiBlacklister) do(ctx context.Context, method, path, sid string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sid != "" {
+		req.Header.Set("X-FTL-SID", sid)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%v %v: unexpected status (%v): %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// execPihole runs the pihole command along with its flags, e.g. `pihole -b` or `pihole -w`,
+// followed by every domain passed as a separate argument, through the executor.
+// No shell is involved, so domains are never interpreted by one.
+// The command is killed if it doesn't finish within `timeout`.
+func execPihole(ctx context.Context, ex Executor, timeout time.Duration, command, domains []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out, err := ex.Exec(ctx, command, domains)
+	if ctx.Err() == context.DeadlineExceeded {
+		return out, fmt.Errorf("pihole command timed out after %v", timeout)
+	}
+
+	return out, err
+}
+
+// execPiholeRetry runs `execPihole`, retrying up to `retries` times with an exponential backoff
+// when the command fails, e.g. while FTL is restarting. It never retries once `ctx` is cancelled.
+// The output and error of the last attempt are returned.
+func execPiholeRetry(ctx context.Context, ex Executor, retries int, timeout time.Duration, command, domains []string) ([]byte, error) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		out, err := execPihole(ctx, ex, timeout, command, domains)
+		if err == nil || attempt == retries || ctx.Err() != nil {
+			return out, err
+		}
+
+		warnf("pihole command failed, retry (%v/%v) in (%v): %v", attempt+1, retries, backoff, err)
+		select {
+		case <-ctx.Done():
+			return out, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// blacklist runs the pihole command, ending with the flags selecting the list, sending the domains in batches of at most `batchSize` domains
+// so the command line never grows beyond the system's argument limit.
+// Consecutive batches are `delay` apart. The output of every batch is aggregated and returned.
+func blacklist(ctx context.Context, ex Executor, command, domains []string, batchSize int, delay time.Duration, retries int, timeout time.Duration) ([]byte, error) {
+	batches := batchDomains(domains, batchSize)
+
+	var output []byte
+	for i, batch := range batches {
+		if err := pauseBetweenBatches(ctx, i, delay); err != nil {
+			return output, fmt.Errorf("batch (%v/%v) not sent: %v", i+1, len(batches), err)
+		}
+		out, err := execPiholeRetry(ctx, ex, retries, timeout, command, batch)
+		output = append(output, out...)
+		if err != nil {
+			return output, fmt.Errorf("batch (%v/%v) failed: %v", i+1, len(batches), err)
+		}
+	}
+
+	return output, nil
+}
+
+// pauseBetweenBatches waits `delay` before any batch but the first, so as not to overwhelm pihole.
+// It returns early with the context error once `ctx` is cancelled.
+func pauseBetweenBatches(ctx context.Context, batch int, delay time.Duration) error {
+	if batch == 0 || delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// inlineFlags matches the Go specific flags group a pattern may start with, e.g. `(?m)`.
+var inlineFlags = regexp.MustCompile(`^\(\?[a-zA-Z]+\)`)
+
+// piholeRegex turns the match patterns into a single pihole regex rule matching any of them.
+// The conversion is best-effort: Go specific flags are dropped, lazy quantifiers made greedy
+// and non-capturing groups made capturing, which doesn't change what a pattern matches as a whole. Pihole lowercases the domains, so dropping `(?i)` is harmless.
+// Pihole has no `\b`: a word boundary starting or ending a pattern becomes a label boundary, `(^|[.-])` and `($|[.-])`,
+// the only non-word characters of a domain. A word boundary anywhere else cannot be translated.
+func piholeRegex(patterns []string) (string, error) {
+	parts := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = inlineFlags.ReplaceAllString(pattern, "")
+		if strings.HasPrefix(pattern, `\b`) {
+			pattern = `(^|[.-])` + strings.TrimPrefix(pattern, `\b`)
+		}
+		if strings.HasSuffix(pattern, `\b`) && !strings.HasSuffix(pattern, `\\b`) {
+			pattern = strings.TrimSuffix(pattern, `\b`) + `($|[.-])`
+		}
+		if strings.Contains(strings.ReplaceAll(pattern, `\\`, ""), `\b`) {
+			return "", fmt.Errorf("cannot convert the pattern (%v) to a pihole regex: a word boundary may only start or end it", pattern)
+		}
+		pattern = strings.NewReplacer("*?", "*", "+?", "+", "??", "?", "(?:", "(").Replace(pattern)
+		parts = append(parts, pattern)
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+
+	return "(" + strings.Join(parts, ")|(") + ")", nil
+}
+
+// wildcardRegex turns a `*` wildcard, like the `*sn-token.googlevideo.com` entries of `COLLAPSE_BY_SN`,
+// into an anchored pihole regex rule matching the same domains. A `*` stays within its label,
+// so `*sn-abc.googlevideo.com` covers `r1---sn-abc.googlevideo.com` but neither `sn-abcd` hosts nor subdomains.
+func wildcardRegex(wildcard string) string {
+	return "^" + strings.ReplaceAll(regexp.QuoteMeta(wildcard), `\*`, "[^.]*") + "$"
+}
+
+// batchDomains splits the domains into consecutive chunks of at most `size` elements.
+// The last chunk holds the remainder and may be smaller.
+func batchDomains(domains []string, size int) [][]string {
+	batches := make([][]string, 0, len(domains)/size+1)
+	for size < len(domains) {
+		domains, batches = domains[size:], append(batches, domains[:size])
+	}
+	if len(domains) > 0 {
+		batches = append(batches, domains)
+	}
+
+	return batches
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+func TestPiholeRegex(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     string
+		wantErr  bool
+	}{
+		{"plain", []string{`sn-[a-z]+\.googlevideo\.com`}, `sn-[a-z]+\.googlevideo\.com`, false},
+		{"flags and lazy quantifiers", []string{`(?i)r+?[0-9]*?x??\.com`}, `r+[0-9]*x?\.com`, false},
+		{"non-capturing group", []string{`a(?:$|[^a-z])`}, `a($|[^a-z])`, false},
+		{"leading and trailing boundaries", []string{`\bads\.example\.com\b`}, `(^|[.-])ads\.example\.com($|[.-])`, false},
+		{"escaped backslash", []string{`a\\b`}, `a\\b`, false},
+		{"several patterns", []string{`\ba\.com`, `b\.com`}, `((^|[.-])a\.com)|(b\.com)`, false},
+		{"inner boundary", []string{`a\bb\.com`}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := piholeRegex(tt.patterns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error (%v), want an error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got rule %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPiholeRegexDefaultPattern checks the rule built from the default pattern, as pihole applies it to whole domains,
+// blocks the same domains the pattern extracts from the logs.
+func TestPiholeRegexDefaultPattern(t *testing.T) {
+	rule, err := piholeRegex([]string{ytblock.DefaultMatchPattern})
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(rule)
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"r4---sn-4g5edned.googlevideo.com", true},
+		{"rr12---sn-abc.googlevideo.com", true},
+		{"xr1---sn-abc.googlevideo.com", false},
+		{"rrr1---sn-abc.googlevideo.com", false},
+		{"r1---sn-abc.googlevideo.community", false},
+		{"r1---sn-abc.googlevideo.com-x.net", false},
+		{"r1---sn-abc.googlevideo.com.evil.net", false},
+		{"r1---sn-abc.googlevideo.com_x.net", false},
+		{"www.youtube.com", false},
+	}
+	for _, tt := range tests {
+		if got := re.MatchString(tt.domain); got != tt.want {
+			t.Errorf("rule (%v) matching (%v): got %v, want %v", rule, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestWildcardRegex(t *testing.T) {
+	rule := wildcardRegex("*sn-abc.googlevideo.com")
+	if want := `^[^.]*sn-abc\.googlevideo\.com$`; rule != want {
+		t.Fatalf("got rule %q, want %q", rule, want)
+	}
+	re := regexp.MustCompile(rule)
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"r1---sn-abc.googlevideo.com", true},
+		{"rr12---sn-abc.googlevideo.com", true},
+		{"r1---sn-abcd.googlevideo.com", false},
+		{"r1---sn-xabc.googlevideo.com", false},
+		{"x.r1---sn-abc.googlevideo.com", false},
+		{"r1---sn-abc.googlevideo.com.evil.net", false},
+	}
+	for _, tt := range tests {
+		if got := re.MatchString(tt.domain); got != tt.want {
+			t.Errorf("rule (%v) matching (%v): got %v, want %v", rule, tt.domain, got, tt.want)
+		}
+	}
+}
+
+// TestCommandExecutor runs the fake pihole of `testdata`, found on the PATH like the real one,
+// checking every domain reaches it as a single argument, never interpreted by a shell.
+func TestCommandExecutor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake pihole is a shell script")
+	}
+	script, err := ioutil.ReadFile(filepath.Join("testdata", "fake-pihole"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "pihole"), script, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	marker := filepath.Join(dir, "injected")
+	domains := []string{"a.googlevideo.com", "$(touch " + marker + ")", "b.googlevideo.com;touch " + marker}
+	out, err := commandExecutor{}.Exec(context.Background(), []string{"pihole", "-b"}, domains)
+	if err != nil {
+		t.Fatalf("exec: %v: %s", err, out)
+	}
+	want := "fake-pihole: -b a.googlevideo.com $(touch " + marker + ") b.googlevideo.com;touch " + marker + "\n"
+	if string(out) != want {
+		t.Errorf("got output %q, want %q", out, want)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("a domain was run by a shell")
+	}
+}
+
+// fakeAPI is a stand-in for the Pi-hole v6 REST API, recording the domains added to the exact deny list.
+type fakeAPI struct {
+	mu      sync.Mutex
+	blocked []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/auth" && r.Method == http.MethodPost:
+		io.WriteString(w, `{"session": {"valid": true, "sid": "test-sid"}}`)
+	case r.URL.Path == "/api/auth" && r.Method == http.MethodDelete:
+	case r.URL.Path == "/api/domains/deny/exact" && r.Method == http.MethodPost:
+		if r.Header.Get("X-FTL-SID") != "test-sid" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Domain []string `json:"domain"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.blocked = append(f.blocked, body.Domain...)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newAPIBlacklister returns the blacklister of the `api` backend configured with the given options.
+func newAPIBlacklister(t *testing.T, options map[string]interface{}) Blacklister {
+	t.Helper()
+	options["PIHOLE_BACKEND"] = backendAPI
+	bl, err := NewBlacklister(newTestConfig(t, options))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bl
+}
+
+func TestAPIThroughProxy(t *testing.T) {
+	api := new(fakeAPI)
+	apiServer := httptest.NewServer(api)
+	defer apiServer.Close()
+
+	// The proxy forwards every request to the fake API, whatever host it was meant for.
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+		mu.Unlock()
+
+		req, err := http.NewRequest(r.Method, apiServer.URL+r.URL.Path, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		req.Header = r.Header.Clone()
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+
+	// The pihole host doesn't resolve, only the proxy reaches it.
+	bl := newAPIBlacklister(t, map[string]interface{}{
+		"PIHOLE_API_URL": "http://pihole.invalid",
+		"HTTP_PROXY_URL": proxy.URL,
+	})
+	domains := []string{"a.googlevideo.com", "b.googlevideo.com"}
+	if err := bl.Block(context.Background(), domains); err != nil {
+		t.Fatalf("block: %v", err)
+	}
+
+	if !reflect.DeepEqual(api.blocked, domains) {
+		t.Errorf("got blocked domains %v, want %v", api.blocked, domains)
+	}
+	want := []string{
+		"POST http://pihole.invalid/api/auth",
+		"POST http://pihole.invalid/api/domains/deny/exact",
+		"DELETE http://pihole.invalid/api/auth",
+	}
+	if !reflect.DeepEqual(proxied, want) {
+		t.Errorf("got proxied requests %v, want %v", proxied, want)
+	}
+}
+
+func TestAPITLS(t *testing.T) {
+	server := httptest.NewTLSServer(new(fakeAPI))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		wantErr bool
+	}{
+		{"custom CA", map[string]interface{}{"PIHOLE_CA_FILE": caFile}, false},
+		{"skip verify", map[string]interface{}{"PIHOLE_INSECURE_SKIP_VERIFY": true}, false},
+		{"unknown authority", map[string]interface{}{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options["PIHOLE_API_URL"] = server.URL
+			bl := newAPIBlacklister(t, tt.options)
+			err := bl.Block(context.Background(), []string{"a.googlevideo.com"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error (%v), want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPITLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, caFile := range []string{filepath.Join(dir, "missing.pem"), notPEM} {
+		cfg := Config{PiholeCAFile: caFile}
+		if _, err := cfg.apiTLSConfig(); err == nil {
+			t.Errorf("got no error for PIHOLE_CA_FILE (%v)", caFile)
+		}
+	}
+}
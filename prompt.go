@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// confirm pops up the confirmation dialogue on `w` and waits for the user to answer on stdin.
+// Pressing Enter picks the default answer `def` ("y" or "n"), if there is one.
+// Without an answer within `timeout`, if not zero, the default answer is picked, or no without one.
+// It reports whether the user said yes.
+func confirm(ctx context.Context, w io.Writer, question, def string, timeout time.Duration) (bool, error) {
+	fmt.Fprintln(w, "-----------")
+	fmt.Fprintf(w, "%v %v\n", question, promptChoices(def))
+	fmt.Fprintln(w, "-----------")
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	input := readRunes(bufio.NewReader(os.Stdin))
+	for {
+		var in runeInput
+		select {
+		case <-ctx.Done():
+			return false, errInterrupted
+		case <-expired:
+			if def == "y" {
+				infof("No answer within (%v), going with the default yes.", timeout)
+				return true, nil
+			}
+			infof("No answer within (%v), going with no.", timeout)
+			return false, nil
+		case in = <-input:
+		}
+
+		rn, err := in.r, in.err
+		if (rn == '\n' || rn == '\r') && def != "" {
+			rn = rune(def[0])
+		}
+
+		switch {
+		case err != nil:
+			return false, &exitError{exitStartupError, fmt.Errorf("could not read input: %v", err)}
+		case rn == 'Y', rn == 'y':
+			return true, nil
+		case rn == 'N', rn == 'n':
+			return false, nil
+		case rn == '\n', rn == '\r':
+			continue
+		default:
+			warnf("Your key (%v) is not supported. Use: Y, y, N, n", rn)
+		}
+	}
+}
+
+// promptChoices returns the answers shown in the confirmation dialogue, the default one capitalized.
+func promptChoices(def string) string {
+	switch def {
+	case "y":
+		return "(Y/n)"
+	case "n":
+		return "(y/N)"
+	default:
+		return "(y/n)"
+	}
+}
+
+// runeInput is a single rune read from the user, or the error that stopped the reading.
+type runeInput struct {
+	r   rune
+	err error
+}
+
+// readRunes reads runes from `r` in the background so the caller can wait for them
+// alongside other events. The channel receives a last value holding the error once reading fails.
+func readRunes(r *bufio.Reader) <-chan runeInput {
+	input := make(chan runeInput)
+	go func() {
+		for {
+			rn, _, err := r.ReadRune()
+			input <- runeInput{rn, err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return input
+}
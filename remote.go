@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// remoteDialTimeout bounds the connection to `REMOTE_HOST`, handshake included.
+const remoteDialTimeout = 10 * time.Second
+
+// defaultRemoteKeyFiles are the private keys tried, from the home directory, when `REMOTE_KEY_FILE` is not set.
+var defaultRemoteKeyFiles = []string{".ssh/id_ed25519", ".ssh/id_ecdsa", ".ssh/id_rsa"}
+
+// sshRemote reads the log files of the pihole found at `REMOTE_HOST`, and runs its pihole commands, over SSH.
+// It is the `Executor` of the `cli` backend and opens the log files of the `logfile` source,
+// so the remote pihole goes through the exact same pipeline as a local one.
+// The connection is opened on first use, and opened again whenever it was lost, e.g. between two cycles.
+type sshRemote struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHRemote returns the remote of `REMOTE_HOST`, given as `[user@]host[:port]`.
+// It authenticates with the SSH agent, if any, and with the private keys of `REMOTE_KEY_FILE` or the default ones,
+// and only trusts the host keys listed in `REMOTE_KNOWN_HOSTS`, `~/.ssh/known_hosts` by default.
+func newSSHRemote(cfg *Config) (*sshRemote, error) {
+	username, addr := splitRemoteHost(cfg.RemoteHost)
+	if username == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("remote: could not find the user to connect to (%v) as, give it as user@host: %v", cfg.RemoteHost, err)
+		}
+		username = u.Username
+	}
+
+	home, _ := os.UserHomeDir()
+	knownHostsFile := cfg.RemoteKnownHosts
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("remote: could not read the known hosts (%v): %v", knownHostsFile, err)
+	}
+
+	auth, err := remoteAuth(cfg.RemoteKeyFile, home)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sshRemote{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            username,
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         remoteDialTimeout,
+		},
+	}, nil
+}
+
+// splitRemoteHost splits `[user@]host[:port]` into the user, empty when missing, and the address to dial.
+func splitRemoteHost(remote string) (username, addr string) {
+	if i := strings.LastIndex(remote, "@"); i >= 0 {
+		username, remote = remote[:i], remote[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(remote); err != nil {
+		remote = net.JoinHostPort(strings.Trim(remote, "[]"), "22")
+	}
+
+	return username, remote
+}
+
+// remoteAuth returns the authentication methods: the SSH agent of `SSH_AUTH_SOCK`, if running,
+// then the private key of `keyFile`, or else the default keys found in `home`.
+func remoteAuth(keyFile, home string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	keyFiles := []string{keyFile}
+	if keyFile == "" {
+		keyFiles = nil
+		for _, name := range defaultRemoteKeyFiles {
+			if p := filepath.Join(home, name); fileExists(p) {
+				keyFiles = append(keyFiles, p)
+			}
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, p := range keyFiles {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("remote: could not read the private key (%v): %v", p, err)
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		var missing *ssh.PassphraseMissingError
+		switch {
+		case errors.As(err, &missing):
+			// Encrypted keys are only usable through the agent.
+			warnf("Skipped the passphrase protected key (%v), add it to the SSH agent instead.", p)
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("remote: could not parse the private key (%v): %v", p, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("remote: no SSH agent nor private key to authenticate with, set REMOTE_KEY_FILE")
+	}
+
+	return methods, nil
+}
+
+// session opens a new session, connecting first when not connected yet or when the connection was lost.
+func (r *sshRemote) session() (*ssh.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client != nil {
+		s, err := r.client.NewSession()
+		if err == nil {
+			return s, nil
+		}
+		// The connection is likely gone, try once with a new one.
+		r.client.Close()
+		r.client = nil
+	}
+
+	client, err := ssh.Dial("tcp", r.addr, r.config)
+	if err != nil {
+		return nil, fmt.Errorf("remote: could not connect to (%v): %v", r.addr, err)
+	}
+	r.client = client
+
+	return client.NewSession()
+}
+
+// close closes the connection, if any.
+func (r *sshRemote) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client == nil {
+		return nil
+	}
+	err := r.client.Close()
+	r.client = nil
+
+	return err
+}
+
+// Exec runs the command on the remote host, until it exits or `ctx` is cancelled.
+// Every argument is quoted, so the remote shell passes them verbatim.
+func (r *sshRemote) Exec(ctx context.Context, command, domains []string) ([]byte, error) {
+	s, err := r.session()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	// Both streams are copied to the output at once, by a goroutine each.
+	var out lockedBuffer
+	s.Stdout = &out
+	s.Stderr = &out
+	if err := s.Start(shellCommand(append(append([]string{}, command...), domains...))); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Wait() }()
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-ctx.Done():
+		_ = s.Signal(ssh.SIGKILL)
+		// Closing the session ends `Wait`, which must be done writing the output before it is read.
+		_ = s.Close()
+		<-done
+		return out.Bytes(), ctx.Err()
+	}
+}
+
+// lockedBuffer is a `bytes.Buffer` safe to write to from several goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of what was written so far.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// findLogFiles returns the paths of the log files of the remote directory `dir`, like `ytblock.FindLogFiles` does locally.
+// When `recursive` is set the unreadable entries are reported to `skip`, as a whole, and left out.
+func (r *sshRemote) findLogFiles(dir, prefix string, recursive, skipActive bool, skip func(path string, err error)) ([]string, error) {
+	args := []string{"find", dir, "-mindepth", "1"}
+	if !recursive {
+		args = append(args, "-maxdepth", "1")
+	}
+	args = append(args, "-type", "f", "-print0")
+
+	s, err := r.session()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	var stdout, stderr bytes.Buffer
+	s.Stdout = &stdout
+	s.Stderr = &stderr
+	err = s.Run(shellCommand(args))
+	if err != nil && (stdout.Len() == 0 || !recursive) {
+		return nil, fmt.Errorf("%v: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil && skip != nil {
+		skip(dir, errors.New(strings.TrimSpace(stderr.String())))
+	}
+
+	var paths []string
+	for _, p := range strings.Split(strings.TrimSuffix(stdout.String(), "\x00"), "\x00") {
+		name := path.Base(p)
+		switch {
+		case p == "":
+		case skipActive && name == prefix:
+		case strings.HasPrefix(name, prefix):
+			paths = append(paths, p)
+		}
+	}
+
+	return paths, nil
+}
+
+// open streams the remote file found at `name`, its size read first so the file can be checked against `MAX_FILE_BYTES`.
+func (r *sshRemote) open(name string) (fs.File, error) {
+	s, err := r.session()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	s.Stderr = &stderr
+	if err := s.Start(shellCommand([]string{"stat", "-L", "-c", "%s", "--", name}) + " && " + shellCommand([]string{"cat", "--", name})); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	f := &remoteFile{session: s, r: bufio.NewReader(stdout), stderr: &stderr, name: name}
+	line, err := f.r.ReadString('\n')
+	if err != nil {
+		werr := s.Wait()
+		s.Close()
+		if werr != nil {
+			return nil, fmt.Errorf("%v: %v", werr, strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	if f.size, err = strconv.ParseInt(strings.TrimSpace(line), 10, 64); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("unexpected size (%v) of remote file (%v)", strings.TrimSpace(line), name)
+	}
+
+	return f, nil
+}
+
+// remoteFile is a log file streamed from the remote host, implementing `fs.File`.
+type remoteFile struct {
+	session *ssh.Session
+	r       *bufio.Reader
+	stderr  *bytes.Buffer
+	name    string
+	size    int64
+	// waitErr is the outcome of the remote command, once waited for at the end of the file.
+	waitErr error
+	waited  bool
+}
+
+// Read reads the content of the file. Once it is all read, the error of the remote command, if any, is returned.
+func (f *remoteFile) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		if !f.waited {
+			f.waited = true
+			if werr := f.session.Wait(); werr != nil {
+				f.waitErr = fmt.Errorf("%v: %v", werr, strings.TrimSpace(f.stderr.String()))
+			}
+		}
+		if f.waitErr != nil {
+			return n, f.waitErr
+		}
+	}
+
+	return n, err
+}
+
+// Stat returns the size of the file, the only information known about it.
+func (f *remoteFile) Stat() (fs.FileInfo, error) {
+	return remoteFileInfo{name: path.Base(f.name), size: f.size}, nil
+}
+
+// Close stops the transfer, whether the file was read entirely or not.
+func (f *remoteFile) Close() error {
+	err := f.session.Close()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+// remoteFileInfo describes a `remoteFile`, a regular file of known name and size.
+type remoteFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi remoteFileInfo) Name() string       { return fi.name }
+func (fi remoteFileInfo) Size() int64        { return fi.size }
+func (fi remoteFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi remoteFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi remoteFileInfo) IsDir() bool        { return false }
+func (fi remoteFileInfo) Sys() interface{}   { return nil }
+
+// shellCommand joins the arguments into a command line for the remote shell, every argument single quoted.
+func shellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSSHServer serves SSH on a local port, running every command as an endless
+// stream of output that only stops once the client closes the session, whatever the signals.
+func newTestSSHServer(t *testing.T) *sshRemote {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSH(conn, config)
+		}
+	}()
+
+	r := &sshRemote{
+		addr: ln.Addr().String(),
+		config: &ssh.ClientConfig{
+			User:            "pi",
+			HostKeyCallback: ssh.FixedHostKey(signer.PublicKey()),
+			Timeout:         remoteDialTimeout,
+		},
+	}
+	t.Cleanup(func() { r.close() })
+
+	return r
+}
+
+func serveTestSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range reqs {
+				req.Reply(req.Type == "exec", nil)
+				if req.Type == "exec" {
+					go func() {
+						for {
+							if _, err := ch.Write([]byte("still blocking\n")); err != nil {
+								return
+							}
+						}
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// TestSSHRemoteExecCancel checks a cancelled command returns once its output is no longer written to.
+func TestSSHRemoteExecCancel(t *testing.T) {
+	r := newTestSSHServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	out, err := r.Exec(ctx, []string{"pihole", "-b"}, []string{"a.googlevideo.com"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error (%v), want (%v)", err, context.DeadlineExceeded)
+	}
+	if len(out) == 0 {
+		t.Error("got no output, want what was written before the cancel")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// reportTopDomains is how many of the most seen domains the run report lists.
+const reportTopDomains = 10
+
+// runReport sums up a run for the `REPORT_FILE`, readable at a glance.
+type runReport struct {
+	started       time.Time
+	took          time.Duration
+	filesScanned  int
+	filesErrored  int
+	lines         int
+	uniqueDomains int
+	top           []ytblock.DomainCount
+}
+
+// newRunReport sums up the run started at `started`, from the processed files and the collected domains.
+func newRunReport(started time.Time, fileMatches []FileMatches, filesErrored int, dm *ytblock.DomainMap) runReport {
+	r := runReport{
+		started:       started,
+		took:          time.Since(started),
+		filesScanned:  len(fileMatches),
+		filesErrored:  filesErrored,
+		uniqueDomains: dm.Len(),
+		top:           dm.TopN(reportTopDomains),
+	}
+	for _, fm := range fileMatches {
+		r.lines += fm.Lines
+	}
+
+	return r
+}
+
+// write writes the report to the file found at `path`, replacing the previous one.
+func (r runReport) write(path string) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Run started:     %v\n", r.started.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Took:            %v\n", r.took)
+	fmt.Fprintf(&b, "Files scanned:   %v\n", r.filesScanned)
+	fmt.Fprintf(&b, "Files errored:   %v\n", r.filesErrored)
+	fmt.Fprintf(&b, "Lines read:      %v\n", r.lines)
+	fmt.Fprintf(&b, "Unique domains:  %v\n", r.uniqueDomains)
+	fmt.Fprintf(&b, "Top (%v) domains by hit count:\n", len(r.top))
+	for _, dc := range r.top {
+		fmt.Fprintf(&b, "%8d  %v\n", dc.Count, dc.Domain)
+	}
+
+	return writeFileAtomic(path, b.Bytes())
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// blacklistLabel stands for the file name of the pihole blacklist entries in the audit and debug output.
+const blacklistLabel = "pihole blacklist"
+
+// exportBlacklist seeds the seen store with the domains already on the pihole blacklist which match the patterns,
+// so a first run doesn't send them again. In dry-run mode the domains are only printed.
+func exportBlacklist(ctx context.Context, cfg *Config, w io.Writer) error {
+	if cfg.SeenStoreFile == "" {
+		return &exitError{exitStartupError, fmt.Errorf("-export-blacklist requires SEEN_STORE_FILE to write the domains to")}
+	}
+
+	bl, err := NewBlacklister(cfg)
+	if err != nil {
+		return &exitError{exitStartupError, fmt.Errorf("unable to start: %v", err)}
+	}
+	extractor, err := cfg.newExtractor(time.Now(), nil)
+	if err != nil {
+		return &exitError{exitStartupError, fmt.Errorf("unable to start: %v", err)}
+	}
+
+	listed, err := bl.Blacklisted(ctx)
+	if err != nil {
+		return &exitError{exitPiholeFailed, fmt.Errorf("could not list the pihole blacklist: %v", err)}
+	}
+
+	// The entries go through the same patterns and excludes as the log lines, so only the hosts this tool blocks are kept.
+	matched := ytblock.NewDomainMap(new(sync.Mutex))
+	entries := make(map[string]struct{}, len(listed))
+	for i, domain := range listed {
+		entries[strings.ToLower(domain)] = struct{}{}
+		extractor.Match([]byte(domain), matched, blacklistLabel, i+1)
+	}
+	matched.RemoveMatching(cfg.Whitelist)
+	// A match within a longer entry, e.g. a subdomain of a video host, is not itself on the blacklist.
+	var domains []string
+	for _, domain := range matched.DomainList() {
+		if _, ok := entries[domain]; ok {
+			domains = append(domains, domain)
+		}
+	}
+	infof("Found (%v) matching domains among the (%v) entries of the pihole blacklist.", len(domains), len(listed))
+
+	if len(domains) == 0 {
+		infof("No domains to seed the seen domains store with.")
+		return nil
+	}
+
+	if cfg.DryRun {
+		for _, domain := range domains {
+			fmt.Fprintln(w, domain)
+		}
+
+		infof("dry-run: (%v) domains NOT written to the seen domains store (%v)", len(domains), cfg.SeenStoreFile)
+		return nil
+	}
+
+	if err := saveSeenStore(cfg.SeenStoreFile, domains, cfg.ResetStore); err != nil {
+		return &exitError{exitStartupError, fmt.Errorf("could not update the seen domains store (%v): %v", cfg.SeenStoreFile, err)}
+	}
+
+	infof("Seeded the seen domains store (%v) with (%v) domains.", cfg.SeenStoreFile, len(domains))
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// shutdownTimeout is how long the HTTP server waits for in-flight requests when stopping.
+const shutdownTimeout = 5 * time.Second
+
+// statusServer exposes the domains collected by the latest scan, and metrics about the scans,
+// over HTTP for monitoring a long-running mode.
+type statusServer struct {
+	domains atomic.Pointer[ytblock.DomainMap]
+	metrics metrics
+}
+
+// publish makes `dm` the map served by the `/domains` endpoint.
+func (s *statusServer) publish(dm *ytblock.DomainMap) {
+	if s == nil {
+		return
+	}
+	s.domains.Store(dm)
+}
+
+// fileProcessed counts a processed log file in the metrics.
+func (s *statusServer) fileProcessed(failed bool) {
+	if s == nil {
+		return
+	}
+	s.metrics.fileProcessed(failed)
+}
+
+// scanDone records a finished scan in the metrics.
+func (s *statusServer) scanDone(domains int, took time.Duration) {
+	if s == nil {
+		return
+	}
+	s.metrics.scanDone(domains, took)
+}
+
+// handler returns the routes served by the status server.
+func (s *statusServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", &s.metrics)
+	mux.HandleFunc("/domains", func(w http.ResponseWriter, r *http.Request) {
+		// Counts takes the map's own lock, so a scan in progress never races the response.
+		counts := []ytblock.DomainCount{}
+		if dm := s.domains.Load(); dm != nil {
+			counts = dm.Counts()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(counts); err != nil {
+			warnf("http: could not write the domains: %v", err)
+		}
+	})
+
+	return mux
+}
+
+// start listens on `addr` and serves the status endpoints until `ctx` is cancelled.
+// The returned channel is closed once the server has shut down.
+func (s *statusServer) start(ctx context.Context, addr string) (<-chan struct{}, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("http: could not listen on (%v): %v", addr, err)
+	}
+
+	srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			errorf("http: server stopped: %v", err)
+		}
+	}()
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			warnf("http: could not shut down cleanly: %v", err)
+		}
+	}()
+
+	infof("Serving the collected domains on (%v).", ln.Addr())
+	return done, nil
+}
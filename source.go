@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// Supported values for the `SOURCE` config option.
+const (
+	sourceLogfile = "logfile"
+	sourceSQLite  = "sqlite"
+)
+
+// defaultFTLDatabase is where Pi-hole keeps its query history when `FTL_DATABASE` is not set.
+const defaultFTLDatabase = "/etc/pihole/pihole-FTL.db"
+
+// Source gathers the domains matching the configured patterns from wherever pihole keeps its queries.
+// Where every domain was seen is recorded to `audit`, if not nil.
+// It returns what was read, and an error if the source could not be used at all.
+type Source interface {
+	Collect(ctx context.Context, registry *ytblock.DomainMap, audit *auditTrail) (*Collection, error)
+}
+
+// Collection sums up what a `Source` read.
+type Collection struct {
+	// Files holds the number of lines and matches of every input read.
+	Files []FileMatches
+	// Errors are those of the inputs that could not be read.
+	Errors []error
+	// Skipped is the number of unreadable entries left out while searching the logs directories.
+	Skipped int
+}
+
+// NewSource returns the `Source` selected by the config. Explicitly given files are always read as log files.
+func NewSource(cfg *Config, report io.Writer) (Source, error) {
+	if cfg.Files != nil {
+		return &logfileSource{cfg: cfg, report: report}, nil
+	}
+
+	switch cfg.Source {
+	case sourceLogfile:
+		return &logfileSource{cfg: cfg, report: report}, nil
+	case sourceSQLite:
+		return &sqliteSource{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("source: unknown source (%v), use (%v) or (%v)", cfg.Source, sourceLogfile, sourceSQLite)
+	}
+}
+
+// warnFileDomains warns when the file contributed more unique domains than `WARN_FILE_DOMAINS`, unless zero,
+// a hint of a log injection or of a pattern matching far too much.
+func (cfg *Config) warnFileDomains(file string, domains int) {
+	if cfg.WarnFileDomains > 0 && domains > cfg.WarnFileDomains {
+		warnf("File (%v) yielded (%v) unique domains, over the (%v) of WARN_FILE_DOMAINS: check the patterns.", file, domains, cfg.WarnFileDomains)
+	}
+}
+
+// logfileSource reads the pihole log files, plain or compressed, with a pool of workers.
+type logfileSource struct {
+	cfg    *Config
+	report io.Writer
+}
+
+// Collect processes the configured log files, or the logs found in the configured logs directories.
+func (s *logfileSource) Collect(ctx context.Context, registry *ytblock.DomainMap, audit *auditTrail) (*Collection, error) {
+	cfg := s.cfg
+	var skipped int
+
+	// Find the log files in the configured logs directories, unless they were given explicitly.
+	filesOfInterest := cfg.Files
+	if filesOfInterest == nil {
+		for _, dir := range cfg.logsDirs() {
+			skip := func(path string, err error) {
+				warnf("Skipped unreadable entry (%v): %v", path, err)
+				skipped++
+			}
+			find := ytblock.FindLogFiles
+			if cfg.remote != nil {
+				find = cfg.remote.findLogFiles
+			}
+			files, err := find(dir, cfg.LogFileNamePrefix, cfg.Recursive, cfg.SkipActiveLog, skip)
+			if err != nil {
+				return nil, fmt.Errorf("could not read files from the configured directory (%v): %v", dir, err)
+			}
+			filesOfInterest = append(filesOfInterest, files...)
+		}
+	}
+
+	extractor, err := cfg.newExtractor(time.Now(), audit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Errors and match counts are collected so that they are reported at the end.
+	var errMu sync.Mutex
+	var fileErrors []error
+	var fileMatches []FileMatches
+	var processed atomic.Int64
+	progress := newProgress(cfg.Progress && !cfg.Quiet, len(filesOfInterest))
+
+	fmt.Fprintln(s.report, ">>> Waiting for all jobs to finish...")
+	extractor.ProcessFiles(ctx, filesOfInterest, registry, func(f string, stats ytblock.FileStats, err error) {
+		if err != nil {
+			warnf("%v", err)
+		}
+		cfg.warnFileDomains(f, stats.Domains)
+		cfg.status.fileProcessed(err != nil)
+
+		errMu.Lock()
+		if err != nil {
+			fileErrors = append(fileErrors, err)
+		}
+		fileMatches = append(fileMatches, FileMatches{File: f, Lines: stats.Lines, Matches: stats.Matches})
+		errMu.Unlock()
+		progress(int(processed.Add(1)))
+	})
+
+	return &Collection{Files: fileMatches, Errors: fileErrors, Skipped: skipped}, nil
+}
+
+// sqliteSource reads the query history of the Pi-hole FTL database.
+type sqliteSource struct {
+	cfg *Config
+}
+
+// Collect matches the domain of every query stored in the database. The database is opened read-only.
+func (s *sqliteSource) Collect(ctx context.Context, registry *ytblock.DomainMap, audit *auditTrail) (*Collection, error) {
+	cfg, path := s.cfg, s.cfg.FTLDatabase
+	now := time.Now()
+	extractor, err := cfg.newExtractor(now, audit)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("could not open the FTL database (%v): %v", path, err)
+	}
+	defer db.Close()
+
+	// Queries are timestamped in seconds since the epoch, so the zero `since` selects them all.
+	// The client is only read when filtering on it, which FTL records along with every query.
+	columns := "domain"
+	if len(cfg.ClientFilter) > 0 {
+		columns += ", client"
+	}
+	query := "SELECT " + columns + " FROM queries WHERE timestamp >= ?"
+	if cfg.OnlyQueries {
+		// FTL stores the A and AAAA query types as 1 and 2.
+		query += " AND type IN (1, 2)"
+	}
+	rows, err := db.QueryContext(ctx, query, cfg.since(now).Unix())
+	if err != nil {
+		return nil, fmt.Errorf("could not query the FTL database (%v): %v", path, err)
+	}
+	defer rows.Close()
+
+	var row, matches int
+	var domain []byte
+	var client sql.NullString
+	dest := []interface{}{&domain}
+	if len(cfg.ClientFilter) > 0 {
+		dest = append(dest, &client)
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("could not read the FTL database (%v): %v", path, err)
+		}
+		row++
+		if !extractor.AllowsClient(client.String) {
+			continue
+		}
+		matches += extractor.Match(domain, registry, path, row)
+	}
+
+	// The registry is empty before the database is read, so it holds just the domains of the database.
+	cfg.warnFileDomains(path, registry.Len())
+	fileMatches := []FileMatches{{File: path, Lines: row, Matches: matches}}
+	if err := rows.Err(); err != nil && ctx.Err() == nil {
+		err = fmt.Errorf("could not read the FTL database (%v): %v", path, err)
+		warnf("%v", err)
+		cfg.status.fileProcessed(true)
+		return &Collection{Files: fileMatches, Errors: []error{err}}, nil
+	}
+	cfg.status.fileProcessed(false)
+
+	debugf("Read (%v) rows from database (%v), (%v) matches.", row, path, matches)
+	infof("Finished processing database (%v).", path)
+
+	return &Collection{Files: fileMatches}, nil
+}
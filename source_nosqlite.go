@@ -0,0 +1,7 @@
+//go:build !((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (amd64 || arm64)))
+
+package main
+
+// sqliteAvailable reports whether the `sqlite` source can be used on this platform.
+// The pure Go sqlite driver doesn't support this one, the `logfile` source still works.
+const sqliteAvailable = false
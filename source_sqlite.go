@@ -0,0 +1,9 @@
+//go:build (darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (amd64 || arm64))
+
+package main
+
+// Registers the pure Go "sqlite" driver, so the binaries cross-compile without cgo to the platforms it supports.
+import _ "modernc.org/sqlite"
+
+// sqliteAvailable reports whether the `sqlite` source can be used on this platform.
+const sqliteAvailable = true
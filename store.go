@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// loadSeenStore returns the set of domains already blocked by previous runs, as recorded at `path`.
+// A missing store is empty. When `reset` is set, the store is left untouched but read as empty,
+// it is only replaced by `saveSeenStore` once the domains were blocked.
+// An empty `path` disables the store.
+func loadSeenStore(path string, reset bool) (map[string]struct{}, error) {
+	if path == "" || reset {
+		return map[string]struct{}{}, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return map[string]struct{}{}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return parseDomains(b), nil
+}
+
+// saveSeenStore records the newly blocked domains in the store found at `path`.
+// When `reset` is set, the store is replaced by the domains instead.
+// An empty `path` disables the store.
+func saveSeenStore(path string, domains []string, reset bool) error {
+	if path == "" || (len(domains) == 0 && !reset) {
+		return nil
+	}
+
+	return writeDomainsFile(path, domains, !reset)
+}
+
+// newDomains returns the domains not present in `seen`, keeping their order.
+func newDomains(domains []string, seen map[string]struct{}) []string {
+	fresh := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if _, ok := seen[domain]; !ok {
+			fresh = append(fresh, domain)
+		}
+	}
+
+	return fresh
+}
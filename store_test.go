@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSeenStoreReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked_domains.txt")
+	if err := saveSeenStore(path, []string{"a.googlevideo.com", "b.googlevideo.com"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reset reads the store as empty, without touching the file.
+	seen, err := loadSeenStore(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 0 {
+		t.Errorf("got (%v) seen domains on reset, want none", len(seen))
+	}
+	seen, err = loadSeenStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 {
+		t.Errorf("got (%v) seen domains after a reset load, want the (2) saved", len(seen))
+	}
+
+	tests := []struct {
+		name  string
+		reset bool
+		want  string
+	}{
+		{"append", false, "a.googlevideo.com\nb.googlevideo.com\nc.googlevideo.com\n"},
+		{"reset", true, "c.googlevideo.com\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := saveSeenStore(path, []string{"a.googlevideo.com", "b.googlevideo.com"}, true); err != nil {
+				t.Fatal(err)
+			}
+			if err := saveSeenStore(path, []string{"c.googlevideo.com"}, tt.reset); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b); got != tt.want {
+				t.Errorf("got store %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDomains(t *testing.T) {
+	seen := map[string]struct{}{"b": {}}
+	got := newDomains([]string{"c", "b", "a"}, seen)
+	if want := []string{"c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/foae/pihole-youtube-block/ytblock"
+)
+
+// unblock releases previously blocked domains by adding them to the pihole whitelist.
+// The domains are read from the given list files, one domain per line and gzip compressed
+// when named `.gz`, or from the configured output file when no list is given.
+func unblock(ctx context.Context, cfg *Config, w io.Writer, lists []string) error {
+	bl, err := NewBlacklister(cfg)
+	if err != nil {
+		return &exitError{exitStartupError, fmt.Errorf("unable to start: %v", err)}
+	}
+
+	var domains []string
+	if len(lists) == 0 {
+		domains, err = readOutput(cfg)
+		if err != nil {
+			return &exitError{exitStartupError, fmt.Errorf("could not read domains from file (%v): %v", cfg.OutputFileName, err)}
+		}
+	} else {
+		merged := make(map[string]struct{})
+		for _, path := range lists {
+			b, err := readOutputFile(path)
+			if err != nil {
+				return &exitError{exitStartupError, fmt.Errorf("could not read domains from file (%v): %v", path, err)}
+			}
+			for domain := range parseDomains(b) {
+				merged[domain] = struct{}{}
+			}
+		}
+		domains = ytblock.SortedDomains(merged)
+	}
+
+	if len(domains) == 0 {
+		infof("No domains to unblock.")
+		return nil
+	}
+
+	// In dry-run mode only show what would have been sent to pihole.
+	if cfg.DryRun {
+		for _, domain := range domains {
+			fmt.Fprintln(w, domain)
+		}
+
+		infof("dry-run: (%v) domains NOT sent to pihole", len(domains))
+		return nil
+	}
+
+	if cfg.PopConfirmationDialogue {
+		question := fmt.Sprintf("Would you like to release those (%v) domains through *your* pihole whitelist?", len(domains))
+		yes, err := confirm(ctx, w, question, cfg.PromptDefault, cfg.promptTimeout())
+		if err != nil {
+			return err
+		}
+		if !yes {
+			infof("No is a no. Bye.")
+			return nil
+		}
+	}
+
+	infof("Adding (%v) domains to the whitelist...", len(domains))
+	if err := bl.Whitelist(ctx, domains); err != nil {
+		return &exitError{exitPiholeFailed, fmt.Errorf("could not send `whitelist domains` command to pihole: %v", err)}
+	}
+
+	infof("Finished.")
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUnblockLists(t *testing.T) {
+	dir := t.TempDir()
+	compressed, err := gzipBytes(domainsText([]string{"b.googlevideo.com", "a.googlevideo.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := []string{filepath.Join(dir, "release.txt.gz"), filepath.Join(dir, "release.txt")}
+	if err := os.WriteFile(lists[0], compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lists[1], domainsText([]string{"c.googlevideo.com", "a.googlevideo.com"}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newTestConfig(t, nil)
+	ex := new(recordingExecutor)
+	cfg.executor = ex
+
+	if err := unblock(context.Background(), cfg, new(bytes.Buffer), lists); err != nil {
+		t.Fatalf("unblock: %v", err)
+	}
+	want := [][]string{{"pihole", "-w", "a.googlevideo.com", "b.googlevideo.com", "c.googlevideo.com"}}
+	if !reflect.DeepEqual(ex.calls, want) {
+		t.Errorf("got pihole commands %v, want %v", joinCalls(ex.calls), joinCalls(want))
+	}
+}
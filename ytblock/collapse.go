@@ -0,0 +1,40 @@
+package ytblock
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// snMatcher extracts the `sn-` token of a YouTube video host, the second capture group of `DefaultMatchPattern`.
+var snMatcher = regexp.MustCompile(DefaultMatchPattern)
+
+// snWildcardFormat is the wildcard covering every numbered host of an `sn-` token, and only them:
+// the `*` stands for the `r` prefix, the token is whole.
+const snWildcardFormat = "*sn-%v.googlevideo.com"
+
+// SNToken returns the `sn-` token of a YouTube video host, e.g. `abc123` for `r1---sn-abc123.googlevideo.com`.
+// It reports false for the domains that are not YouTube video hosts.
+func SNToken(domain string) (string, bool) {
+	m := snMatcher.FindStringSubmatch(domain)
+	if m == nil || m[2] == "" {
+		return "", false
+	}
+
+	return m[2], true
+}
+
+// CollapseBySN returns a copy of the domain map where the YouTube video hosts sharing an `sn-` token
+// are merged into a single `*sn-token.googlevideo.com` wildcard, their counts summed.
+// The other domains are kept as they are.
+func (dm DomainMap) CollapseBySN() *DomainMap {
+	collapsed := NewDomainMap(new(sync.Mutex))
+	for domain, count := range dm.Domains() {
+		if token, ok := SNToken(domain); ok {
+			domain = fmt.Sprintf(snWildcardFormat, token)
+		}
+		collapsed.m[domain] += count
+	}
+
+	return collapsed
+}
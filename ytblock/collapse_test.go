@@ -0,0 +1,31 @@
+package ytblock
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCollapseBySN(t *testing.T) {
+	dm := NewDomainMap(new(sync.Mutex))
+	for domain, count := range map[string]int{
+		"r1---sn-abc.googlevideo.com":  2,
+		"rr7---sn-abc.googlevideo.com": 3,
+		"r1---sn-abcd.googlevideo.com": 1,
+		"www.youtube.com":              4,
+	} {
+		for i := 0; i < count; i++ {
+			dm.Insert(domain)
+		}
+	}
+
+	got := dm.CollapseBySN().Domains()
+	want := map[string]int{
+		"*sn-abc.googlevideo.com":  5,
+		"*sn-abcd.googlevideo.com": 1,
+		"www.youtube.com":          4,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
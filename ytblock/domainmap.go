@@ -0,0 +1,229 @@
+package ytblock
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DomainMap holds the gathered domains from the log files.
+// The underlying map consists of key: domain, value: number of occurrences.
+type DomainMap struct {
+	m map[string]int
+	l sync.Locker
+	// added receives the domains seen for the first time, when set through `Notify`.
+	added chan<- string
+}
+
+// DomainCount pairs a gathered domain with its number of occurrences.
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+// Insert takes care of adding domains the the domain map.
+func (dm DomainMap) Insert(s string) {
+	dm.l.Lock()
+	dm.m[s]++
+	if dm.m[s] == 1 {
+		dm.notify(s)
+	}
+	dm.l.Unlock()
+}
+
+// count adds an occurrence of the matched domain, making the registry a `counter`.
+func (dm DomainMap) count(domain []byte) {
+	dm.l.Lock()
+	s := string(domain)
+	dm.m[s]++
+	if dm.m[s] == 1 {
+		dm.notify(s)
+	}
+	dm.l.Unlock()
+}
+
+// merge adds the counts of a file's tally.
+func (dm DomainMap) merge(t tally) {
+	if len(t) == 0 {
+		return
+	}
+
+	dm.l.Lock()
+	for domain, n := range t {
+		if _, ok := dm.m[domain]; !ok {
+			dm.notify(domain)
+		}
+		dm.m[domain] += *n
+	}
+	dm.l.Unlock()
+}
+
+// Notify makes the domain map send to `ch` every domain the first time it is added.
+// The sends happen under the lock, so a full channel holds the inserts back until it is drained.
+// It must be called before any domain is added.
+func (dm *DomainMap) Notify(ch chan<- string) {
+	dm.added = ch
+}
+
+// notify sends a domain seen for the first time to the `Notify` channel, if any.
+// The lock must be held.
+func (dm DomainMap) notify(domain string) {
+	if dm.added != nil {
+		dm.added <- domain
+	}
+}
+
+// Len returns the number of unique domains gathered so far.
+func (dm DomainMap) Len() int {
+	dm.l.Lock()
+	defer dm.l.Unlock()
+
+	return len(dm.m)
+}
+
+// Prune removes the domains seen less than `min` times.
+func (dm DomainMap) Prune(min int) {
+	dm.l.Lock()
+	for domain, count := range dm.m {
+		if count < min {
+			delete(dm.m, domain)
+		}
+	}
+	dm.l.Unlock()
+}
+
+// RemoveMatching removes the domains matching any of the patterns.
+// A pattern is either an exact domain or a `*.suffix` wildcard matching every subdomain of `suffix`.
+func (dm DomainMap) RemoveMatching(patterns []string) {
+	if len(patterns) == 0 {
+		return
+	}
+
+	dm.l.Lock()
+	for domain := range dm.m {
+		for _, p := range patterns {
+			if MatchesDomainPattern(domain, p) {
+				delete(dm.m, domain)
+				break
+			}
+		}
+	}
+	dm.l.Unlock()
+}
+
+// MatchesDomainPattern reports whether the domain matches an exact or `*.suffix` wildcard pattern.
+func MatchesDomainPattern(domain, pattern string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(domain, pattern[1:])
+	}
+
+	return domain == pattern
+}
+
+// Domains returns a snapshot of the domain map.
+// The copy is safe to use while other goroutines keep inserting domains.
+func (dm DomainMap) Domains() map[string]int {
+	dm.l.Lock()
+	defer dm.l.Unlock()
+
+	snapshot := make(map[string]int, len(dm.m))
+	for domain, count := range dm.m {
+		snapshot[domain] = count
+	}
+
+	return snapshot
+}
+
+// Snapshot returns an independent copy of the domain map, taken under a single lock.
+func (dm DomainMap) Snapshot() *DomainMap {
+	return &DomainMap{m: dm.Domains(), l: new(sync.Mutex)}
+}
+
+// DomainList returns the gathered domains as a sorted slice.
+func (dm DomainMap) DomainList() []string {
+	dm.l.Lock()
+	defer dm.l.Unlock()
+
+	return SortedDomains(dm.m)
+}
+
+// SortedDomains returns the keys of a domain set or domain map in alphabetical order,
+// so every list written or sent is stable across runs.
+func SortedDomains[V any](set map[string]V) []string {
+	domains := make([]string, 0, len(set))
+	for domain := range set {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	return domains
+}
+
+// TopN returns at most `n` domains ordered by number of occurrences, highest first.
+// Domains with the same number of occurrences are ordered by name.
+func (dm DomainMap) TopN(n int) []DomainCount {
+	counts := dm.Counts()
+	if n < len(counts) {
+		counts = counts[:n]
+	}
+
+	return counts
+}
+
+// Counts returns every domain with its number of occurrences, highest first.
+// Domains with the same number of occurrences are ordered by name.
+func (dm DomainMap) Counts() []DomainCount {
+	dm.l.Lock()
+	counts := make([]DomainCount, 0, len(dm.m))
+	for domain, count := range dm.m {
+		counts = append(counts, DomainCount{Domain: domain, Count: count})
+	}
+	dm.l.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Domain < counts[j].Domain
+	})
+
+	return counts
+}
+
+// MarshalJSON encodes the domains as an array of `{"domain": "...", "count": N}` objects,
+// ordered by number of occurrences, highest first.
+func (dm DomainMap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dm.Counts())
+}
+
+// UnmarshalJSON decodes an array of `{"domain": "...", "count": N}` objects,
+// adding the counts to the domains already present.
+func (dm *DomainMap) UnmarshalJSON(b []byte) error {
+	var counts []DomainCount
+	if err := json.Unmarshal(b, &counts); err != nil {
+		return err
+	}
+
+	dm.l.Lock()
+	for _, dc := range counts {
+		dm.m[dc.Domain] += dc.Count
+	}
+	dm.l.Unlock()
+
+	return nil
+}
+
+// DomainsToString returns the gathered domains into a single string, space separated.
+// There is no leading or trailing whitespace.
+func (dm DomainMap) DomainsToString() string {
+	return strings.Join(dm.DomainList(), " ")
+}
+
+// NewDomainMap returns a pointer to a `DomainMap`.
+func NewDomainMap(l sync.Locker) *DomainMap {
+	return &DomainMap{
+		m: make(map[string]int, 0),
+		l: l,
+	}
+}
@@ -0,0 +1,198 @@
+package ytblock
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDomainsToString(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		want    string
+	}{
+		{"empty", nil, ""},
+		{"single", []string{"a.googlevideo.com"}, "a.googlevideo.com"},
+		{"sorted once", []string{"c.com", "a.com", "b.com", "a.com"}, "a.com b.com c.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := NewDomainMap(new(sync.Mutex))
+			for _, domain := range tt.domains {
+				dm.Insert(domain)
+			}
+			if got := dm.DomainsToString(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainsToStringSeparators(t *testing.T) {
+	dm := NewDomainMap(new(sync.Mutex))
+	for _, domain := range []string{"a.com", "b.com", "c.com"} {
+		dm.Insert(domain)
+	}
+
+	got := dm.DomainsToString()
+	if n := strings.Count(got, " "); n != 2 {
+		t.Errorf("got (%v) spaces in %q, want 2", n, got)
+	}
+	if strings.TrimSpace(got) != got {
+		t.Errorf("got leading or trailing whitespace in %q", got)
+	}
+}
+
+// newCountedDomainMap returns a domain map holding the domains, each inserted as many times as its count.
+func newCountedDomainMap(counts map[string]int) *DomainMap {
+	dm := NewDomainMap(new(sync.Mutex))
+	for domain, count := range counts {
+		for i := 0; i < count; i++ {
+			dm.Insert(domain)
+		}
+	}
+
+	return dm
+}
+
+func TestTopN(t *testing.T) {
+	dm := newCountedDomainMap(map[string]int{"d.com": 1, "c.com": 3, "a.com": 3, "b.com": 5})
+	tests := []struct {
+		n    int
+		want []DomainCount
+	}{
+		{0, []DomainCount{}},
+		{1, []DomainCount{{"b.com", 5}}},
+		{3, []DomainCount{{"b.com", 5}, {"a.com", 3}, {"c.com", 3}}},
+		{10, []DomainCount{{"b.com", 5}, {"a.com", 3}, {"c.com", 3}, {"d.com", 1}}},
+	}
+	for _, tt := range tests {
+		if got := dm.TopN(tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopN(%v): got %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	counts := map[string]int{"a.com": 1, "b.com": 2, "c.com": 3}
+	tests := []struct {
+		min  int
+		want []string
+	}{
+		{0, []string{"a.com", "b.com", "c.com"}},
+		{1, []string{"a.com", "b.com", "c.com"}},
+		{2, []string{"b.com", "c.com"}},
+		{3, []string{"c.com"}},
+		{4, []string{}},
+	}
+	for _, tt := range tests {
+		dm := newCountedDomainMap(counts)
+		dm.Prune(tt.min)
+		if got := dm.DomainList(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Prune(%v): got %v, want %v", tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveMatching(t *testing.T) {
+	counts := map[string]int{"example.com": 1, "a.example.com": 1, "b.a.example.com": 1, "badexample.com": 1, "other.net": 1}
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"none", nil, []string{"a.example.com", "b.a.example.com", "badexample.com", "example.com", "other.net"}},
+		{"exact", []string{"example.com"}, []string{"a.example.com", "b.a.example.com", "badexample.com", "other.net"}},
+		{"wildcard", []string{"*.example.com"}, []string{"badexample.com", "example.com", "other.net"}},
+		{"both", []string{"*.example.com", "example.com", "other.net"}, []string{"badexample.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := newCountedDomainMap(counts)
+			dm.RemoveMatching(tt.patterns)
+			if got := dm.DomainList(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainListSorted(t *testing.T) {
+	want := []string{"a.com", "b.a.com", "b.com", "z.net"}
+	orders := [][]string{
+		{"z.net", "b.com", "b.a.com", "a.com"},
+		{"b.a.com", "a.com", "z.net", "b.com"},
+		{"a.com", "b.a.com", "b.com", "z.net"},
+	}
+	for _, order := range orders {
+		dm := NewDomainMap(new(sync.Mutex))
+		for _, domain := range order {
+			dm.Insert(domain)
+		}
+		if got := dm.DomainList(); !reflect.DeepEqual(got, want) {
+			t.Errorf("inserted %v: got %v, want %v", order, got, want)
+		}
+		if got := SortedDomains(dm.Domains()); !reflect.DeepEqual(got, want) {
+			t.Errorf("inserted %v: got sorted domains %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestDomainMapJSON(t *testing.T) {
+	dm := newCountedDomainMap(map[string]int{"a.com": 2, "b.com": 5, "c.com": 2})
+	b, err := json.Marshal(dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"domain":"b.com","count":5},{"domain":"a.com","count":2},{"domain":"c.com","count":2}]`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+
+	decoded := NewDomainMap(new(sync.Mutex))
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decoded.Domains(), dm.Domains(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v after a round trip, want %v", got, want)
+	}
+
+	// Decoding adds to the counts already there.
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decoded.Domains(), map[string]int{"a.com": 4, "b.com": 10, "c.com": 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v after decoding twice, want %v", got, want)
+	}
+}
+
+// TestDomainsConcurrentInsert iterates over the domains while other goroutines keep inserting, run it with `-race`.
+func TestDomainsConcurrentInsert(t *testing.T) {
+	dm := NewDomainMap(new(sync.Mutex))
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				dm.Insert(fmt.Sprintf("r%v---sn-%v.googlevideo.com", i%50, w))
+			}
+		}(w)
+	}
+
+	for i := 0; i < 100; i++ {
+		for domain, count := range dm.Domains() {
+			if count <= 0 {
+				t.Errorf("got count (%v) for (%v)", count, domain)
+			}
+		}
+	}
+	wg.Wait()
+
+	if got := dm.Len(); got != 200 {
+		t.Errorf("got (%v) domains, want 200", got)
+	}
+}
@@ -0,0 +1,694 @@
+// Package ytblock extracts the domains serving YouTube videos, or those matching any other pattern,
+// from the logs pihole writes. It powers the `ytblock` command and can be embedded in other programs.
+package ytblock
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultPrefix selects the log files when `Options.Prefix` is empty.
+const defaultPrefix = "pihole.log"
+
+// defaultReadBufferBytes is the size of every read buffer when `Options.ReadBufferBytes` is not set.
+const defaultReadBufferBytes = 4096
+
+// defaultMaxLineBytes is the longest line processed when `Options.MaxLineBytes` is not set.
+// Longer ones are no log entry, and a file without any newline would otherwise be held in memory whole.
+const defaultMaxLineBytes = 1 << 20
+
+// Options tune how the domains are extracted. The zero value extracts the YouTube video hosts
+// from every `pihole.log*` file, with one worker per CPU.
+type Options struct {
+	// Patterns are the regular expressions extracting the domains from every line, `DefaultMatchPattern` when empty.
+	Patterns []string
+	// Matchers, if not empty, are used instead of compiling `Patterns`.
+	Matchers []*regexp.Regexp
+	// ExcludePatterns are the regular expressions dropping the matched domains, even though a pattern matched them.
+	ExcludePatterns []string
+	// Excludes, if not empty, are used instead of compiling `ExcludePatterns`.
+	Excludes []*regexp.Regexp
+	// Prefix selects the log files by name, `pihole.log` when empty.
+	Prefix string
+	// Recursive also searches the subdirectories.
+	Recursive bool
+	// SkipActive leaves out the live log, named exactly like `Prefix`.
+	SkipActive bool
+	// MaxFileBytes skips the larger files and stops reading compressed ones after as many uncompressed bytes. Zero means no limit.
+	MaxFileBytes int64
+	// ReadBufferBytes sizes the read buffers, 4096 when zero.
+	ReadBufferBytes int
+	// MaxLineBytes skips the longer lines, 1 MiB when zero.
+	MaxLineBytes int
+	// Since skips the lines logged before it, unless zero.
+	Since time.Time
+	// OnlyQueries only considers the A and AAAA queries.
+	OnlyQueries bool
+	// Clients, if not empty, only considers the lines of the queries made by these clients, IP addresses or CIDRs,
+	// e.g. `192.168.1.23` or `192.168.1.0/24`. The lines without a client are then left out.
+	Clients []string
+	// Workers is how many files are processed in parallel, the number of CPUs when zero.
+	Workers int
+	// OnMatch, if not nil, is called with every match along with the file and line it was found on.
+	// It is called from several goroutines at once.
+	OnMatch func(domain, file string, line int)
+	// Infof and Warnf, if not nil, receive the progress and the warnings.
+	Infof, Warnf func(format string, args ...interface{})
+	// Verbosity, when 1, passes the number of lines and matches of every file to `Debugf`;
+	// when 2 or more, also every matched line. Nothing is formatted when zero.
+	Verbosity int
+	// Debugf, if not nil, receives the debug output selected by `Verbosity`.
+	Debugf func(format string, args ...interface{})
+	// Open, if not nil, opens the log files instead of `os.Open`, e.g. to read them from another machine.
+	Open func(name string) (fs.File, error)
+}
+
+// Extractor extracts the domains from the log files, as tuned by its options.
+// It is safe for concurrent use.
+type Extractor struct {
+	opts Options
+	// keep tells which lines to process, nil meaning all of them.
+	keep func(line []byte) bool
+	// clients holds the parsed `Options.Clients`.
+	clients []*net.IPNet
+	// readers and gzipReaders are reused across files, sparing their buffers to the garbage collector.
+	readers     sync.Pool
+	gzipReaders sync.Pool
+}
+
+// NewExtractor returns an `Extractor` with the given options, compiling its patterns.
+func NewExtractor(opts Options) (*Extractor, error) {
+	if len(opts.Matchers) == 0 {
+		patterns := opts.Patterns
+		if len(patterns) == 0 {
+			patterns = []string{DefaultMatchPattern}
+		}
+		for _, pattern := range patterns {
+			m, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid match pattern (%v): %v", pattern, err)
+			}
+			opts.Matchers = append(opts.Matchers, m)
+		}
+	}
+	if len(opts.Excludes) == 0 {
+		for _, pattern := range opts.ExcludePatterns {
+			m, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern (%v): %v", pattern, err)
+			}
+			opts.Excludes = append(opts.Excludes, m)
+		}
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = defaultPrefix
+	}
+	if opts.ReadBufferBytes <= 0 {
+		opts.ReadBufferBytes = defaultReadBufferBytes
+	}
+	if opts.MaxLineBytes <= 0 {
+		opts.MaxLineBytes = defaultMaxLineBytes
+	}
+	if opts.Open == nil {
+		opts.Open = func(name string) (fs.File, error) { return os.Open(name) }
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	clients, err := ParseClients(opts.Clients)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Extractor{opts: opts, clients: clients, keep: newLineFilter(opts.Since, opts.OnlyQueries, clients)}
+	e.readers.New = func() interface{} {
+		return bufio.NewReaderSize(nil, opts.ReadBufferBytes)
+	}
+
+	return e, nil
+}
+
+// reader returns a pooled reader of `r`, to give back with `releaseReader` once done.
+func (e *Extractor) reader(r io.Reader) *bufio.Reader {
+	br := e.readers.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+// releaseReader gives the reader back to the pool, without holding onto what it read.
+func (e *Extractor) releaseReader(br *bufio.Reader) {
+	br.Reset(nil)
+	e.readers.Put(br)
+}
+
+// gzipReader returns a pooled decompressor of `r`, to give back with `releaseGzipReader` once done.
+func (e *Extractor) gzipReader(r io.Reader) (*gzip.Reader, error) {
+	gz, ok := e.gzipReaders.Get().(*gzip.Reader)
+	if !ok {
+		return gzip.NewReader(r)
+	}
+	if err := gz.Reset(r); err != nil {
+		e.gzipReaders.Put(gz)
+		return nil, err
+	}
+
+	return gz, nil
+}
+
+// releaseGzipReader closes the decompressor and gives it back to the pool.
+func (e *Extractor) releaseGzipReader(gz *gzip.Reader) {
+	gz.Close()
+	e.gzipReaders.Put(gz)
+}
+
+// infof logs progress through `Infof`, if set.
+func (e *Extractor) infof(format string, args ...interface{}) {
+	if e.opts.Infof != nil {
+		e.opts.Infof(format, args...)
+	}
+}
+
+// warnf logs a warning through `Warnf`, if set.
+func (e *Extractor) warnf(format string, args ...interface{}) {
+	if e.opts.Warnf != nil {
+		e.opts.Warnf(format, args...)
+	}
+}
+
+// debugf logs debug output through `Debugf`, if set and `Verbosity` is at least `level`.
+// Callers formatting costly arguments check `debugging` first.
+func (e *Extractor) debugf(level int, format string, args ...interface{}) {
+	if e.debugging(level) {
+		e.opts.Debugf(format, args...)
+	}
+}
+
+// debugging reports whether debug output of the given level is wanted.
+func (e *Extractor) debugging(level int) bool {
+	return e.opts.Debugf != nil && e.opts.Verbosity >= level
+}
+
+// FileStats counts what was read from a log file.
+type FileStats struct {
+	// Lines is the number of lines read, including those filtered out.
+	Lines int
+	// Matches is the number of domains matched, counting every occurrence.
+	Matches int
+	// Domains is the number of unique domains matched.
+	Domains int
+}
+
+// Extract returns the domains found in the log files of `dir`, with their number of occurrences.
+// The files that could not be processed are reported in the error, the domains of the others are still returned.
+func Extract(dir string, opts Options) (map[string]int, error) {
+	e, err := NewExtractor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := NewDomainMap(new(sync.Mutex))
+	var mu sync.Mutex
+	var errs []error
+	err = e.ProcessDir(context.Background(), dir, registry, func(_ string, _ FileStats, err error) {
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.Domains(), errors.Join(errs...)
+}
+
+// ProcessDir extracts the domains of the log files found in `dir` into the registry, like `ProcessFiles`.
+// It fails only when the directory cannot be read.
+func (e *Extractor) ProcessDir(ctx context.Context, dir string, registry *DomainMap, done func(file string, stats FileStats, err error)) error {
+	files, err := FindLogFiles(dir, e.opts.Prefix, e.opts.Recursive, e.opts.SkipActive, func(path string, err error) {
+		e.warnf("Skipped unreadable entry (%v): %v", path, err)
+	})
+	if err != nil {
+		return err
+	}
+
+	e.ProcessFiles(ctx, files, registry, done)
+	return nil
+}
+
+// ProcessFiles extracts the domains of the files into the registry, `Options.Workers` files at a time.
+// `done` is called with the outcome of every processed file, from several goroutines at once.
+// Once `ctx` is cancelled, the files being processed are finished and the remaining ones skipped.
+func (e *Extractor) ProcessFiles(ctx context.Context, files []string, registry *DomainMap, done func(file string, stats FileStats, err error)) {
+	var wg sync.WaitGroup
+	wg.Add(len(files))
+	jobs := make(chan string, len(files))
+	for i := 0; i < e.opts.Workers; i++ {
+		go func() {
+			for f := range jobs {
+				if ctx.Err() == nil {
+					stats, err := e.ProcessFile(f, registry)
+					done(f, stats, err)
+				}
+				wg.Done()
+			}
+		}()
+	}
+
+	for _, f := range files {
+		jobs <- f
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// gzipMagic are the first bytes of every gzip compressed file.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// DefaultMatchPattern extracts the YouTube video hosts, used when no pattern is given.
+// It matches `r4---sn-4g5edned.googlevideo.com` along with its variants: any number in the `r` or `rr` prefix,
+// any number of dashes and any case. Both dots are escaped, it starts at a word boundary and ends where the host does,
+// after a single optional trailing dot, at the end of the line or at a character no host name may hold, so a longer host,
+// e.g. `googlevideo.community`, `googlevideo.com-x.net` or `googlevideo.com.evil.net`, isn't cut into one.
+// Neither `_` nor `/` end it either. Those characters are part of the match, `Match` leaves them out of the domain.
+// The capture groups are the `r` number and the `sn-` token.
+const DefaultMatchPattern = `(?i)\br{1,2}([0-9]+)-+sn-([a-z0-9-]+)\.googlevideo\.com\.?(?:$|[^a-z0-9._/-])`
+
+// FindLogFiles returns the paths of the log files in `dir` whose name starts with `prefix`.
+// When `recursive` is set, the whole directory tree under `dir` is searched: the unreadable entries below `dir`
+// are then passed to `skip`, if not nil, and left out rather than failing the whole search.
+// When `skipActive` is set, the live log named exactly `prefix` is left out.
+func FindLogFiles(dir, prefix string, recursive, skipActive bool, skip func(path string, err error)) ([]string, error) {
+	if !recursive {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		paths := filterLogFiles(files, prefix, skipActive)
+		for i, name := range paths {
+			paths[i] = filepath.Join(dir, name)
+		}
+		return paths, nil
+	}
+
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil && path == dir:
+			return err
+		case err != nil:
+			if skip != nil {
+				skip(path, err)
+			}
+			// An unreadable directory is reported once by its entry, then skipped altogether.
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		case d.IsDir():
+			return nil
+		case skipActive && d.Name() == prefix:
+			return nil
+		case strings.HasPrefix(d.Name(), prefix):
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	return paths, err
+}
+
+// filterLogFiles returns the names of the regular files whose name starts with the given prefix,
+// leaving out the one named exactly like the prefix when `skipActive` is set.
+func filterLogFiles(files []os.FileInfo, prefix string, skipActive bool) []string {
+	filesOfInterest := make([]string, 0, len(files))
+	for _, f := range files {
+		switch {
+		case f.IsDir():
+			continue
+		case skipActive && f.Name() == prefix:
+			continue
+		case strings.HasPrefix(f.Name(), prefix):
+			filesOfInterest = append(filesOfInterest, f.Name())
+		}
+	}
+
+	return filesOfInterest
+}
+
+// ProcessFile extracts the domains found in the log file `f` into the registry.
+// Files larger than `Options.MaxFileBytes` are skipped, compressed files stop being read after
+// that many uncompressed bytes; zero means no limit.
+// It returns what was read, even when it fails midway.
+func (e *Extractor) ProcessFile(f string, registry *DomainMap) (FileStats, error) {
+	maxBytes := e.opts.MaxFileBytes
+
+	openFile, err := e.opts.Open(f)
+	if err != nil {
+		return FileStats{}, fmt.Errorf("processFile: skipped unreadable file (%v): %v", f, err)
+	}
+	defer openFile.Close()
+
+	if fi, err := openFile.Stat(); err == nil && maxBytes > 0 && fi.Size() > maxBytes {
+		e.warnf("Skipped file (%v): its size (%v bytes) is over the limit (%v bytes).", f, fi.Size(), maxBytes)
+		return FileStats{}, nil
+	}
+
+	// Compressed files are recognized by their content, whatever their name.
+	fileReader := e.reader(openFile)
+	defer e.releaseReader(fileReader)
+	r := fileReader
+	var gz *gzip.Reader
+	var limited *io.LimitedReader
+	if magic, _ := r.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err = e.gzipReader(r)
+		if err != nil {
+			return FileStats{}, fmt.Errorf("processFile: skipped unreadable gzip file (%v): %v", f, err)
+		}
+
+		if maxBytes > 0 {
+			limited = &io.LimitedReader{R: gz, N: maxBytes}
+			r = e.reader(limited)
+		} else {
+			r = e.reader(gz)
+		}
+		defer e.releaseReader(r)
+	}
+
+	stats, err := e.scanLines(r, f, registry)
+	// Release the decompressor as soon as the file is read rather than when returning.
+	if gz != nil {
+		e.releaseGzipReader(gz)
+	}
+	if err != nil {
+		return stats, fmt.Errorf("processFile: could not read file (%v): %v", f, err)
+	}
+
+	if limited != nil && limited.N <= 0 {
+		e.warnf("Stopped reading file (%v) after (%v) uncompressed bytes, the configured limit.", f, maxBytes)
+	}
+
+	e.debugf(1, "Read (%v) lines from file (%v), (%v) matches.", stats.Lines, f, stats.Matches)
+	e.infof("Finished processing file (%v).", f)
+
+	return stats, nil
+}
+
+// scanLines reads `r`, the content of the file `f`, line-by-line, inserting every match into the registry.
+// The lines filtered out by the options are skipped, as are those longer than `Options.MaxLineBytes`.
+// It returns what was read, even when failing midway.
+func (e *Extractor) scanLines(r *bufio.Reader, f string, registry *DomainMap) (FileStats, error) {
+	var lineNumber, matches int
+	var pending []byte
+	var skipping bool
+	// The matches are tallied per file, then added to the registry at once, even when failing midway.
+	t := make(tally)
+	defer registry.merge(t)
+	stats := func() FileStats {
+		return FileStats{Lines: lineNumber, Matches: matches, Domains: len(t)}
+	}
+
+	process := func(line []byte) {
+		lineNumber++
+		if e.keep != nil && !e.keep(line) {
+			return
+		}
+		matches += e.match(line, t, f, lineNumber)
+	}
+	skip := func() {
+		lineNumber++
+		e.warnf("Skipped line (%v) of file (%v): it is longer than the limit (%v bytes).", lineNumber, f, e.opts.MaxLineBytes)
+	}
+
+	for {
+		line, lineTooLong, err := r.ReadLine()
+		switch {
+		case err == io.EOF:
+			// A last line longer than the buffer, without a trailing newline, e.g. of a live log, is still pending.
+			switch {
+			case skipping:
+				skip()
+			case len(pending) > 0:
+				process(pending)
+			}
+			return stats(), nil
+		case err != nil:
+			return stats(), err
+		case skipping:
+			// The rest of a line over the limit is dropped as it is read, up to its end.
+			if !lineTooLong {
+				skipping = false
+				skip()
+			}
+			continue
+		case len(pending)+len(line) > e.opts.MaxLineBytes:
+			pending = pending[:0]
+			if lineTooLong {
+				skipping = true
+			} else {
+				skip()
+			}
+			continue
+		case lineTooLong:
+			// The line doesn't fit in the reader's buffer; keep its fragments until its end is read.
+			pending = append(pending, line...)
+			continue
+		}
+
+		if len(pending) > 0 {
+			line = append(pending, line...)
+			pending = pending[:0]
+		}
+
+		process(line)
+	}
+}
+
+// queryActions mark the dnsmasq log lines of genuine A and AAAA lookups, as opposed to their replies or cache hits.
+var queryActions = [][]byte{[]byte("query[A] "), []byte("query[AAAA] ")}
+
+// newLineFilter returns the function telling which log lines to process: those logged since `since`, unless zero,
+// only the A and AAAA queries when `onlyQueries` is set, and only the queries of `clients`, unless empty.
+// It returns nil when every line is to be processed.
+func newLineFilter(since time.Time, onlyQueries bool, clients []*net.IPNet) func(line []byte) bool {
+	if since.IsZero() && !onlyQueries && len(clients) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	return func(line []byte) bool {
+		if onlyQueries && !isQuery(line) {
+			return false
+		}
+		if len(clients) > 0 && !containsClient(clients, lineClient(line)) {
+			return false
+		}
+		if ts, ok := lineTime(line, now); !since.IsZero() && ok && ts.Before(since) {
+			return false
+		}
+		return true
+	}
+}
+
+// ParseClients parses the IP addresses and CIDRs of `Options.Clients`, an address standing for itself alone.
+func ParseClients(clients []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(clients))
+	for _, client := range clients {
+		if _, n, err := net.ParseCIDR(client); err == nil {
+			nets = append(nets, n)
+			continue
+		}
+		ip := net.ParseIP(client)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid client (%v), use an IP address or a CIDR", client)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return nets, nil
+}
+
+// AllowsClient reports whether the queries of the client, an IP address, are considered:
+// always when `Options.Clients` is empty, never for an unparseable address otherwise.
+func (e *Extractor) AllowsClient(client string) bool {
+	return len(e.clients) == 0 || containsClient(e.clients, net.ParseIP(client))
+}
+
+// containsClient reports whether the IP address belongs to any of the networks. A nil address belongs to none.
+func containsClient(clients []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range clients {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientMarker precedes the address of the client in the dnsmasq query lines, e.g. `query[A] example.com from 192.168.1.23`.
+var clientMarker = []byte(" from ")
+
+// lineClient returns the address of the client the log line is about, nil when there is none.
+func lineClient(line []byte) net.IP {
+	i := bytes.LastIndex(line, clientMarker)
+	if i < 0 {
+		return nil
+	}
+	rest := line[i+len(clientMarker):]
+	if end := bytes.IndexAny(rest, " \t"); end >= 0 {
+		rest = rest[:end]
+	}
+
+	return net.ParseIP(string(rest))
+}
+
+// isQuery reports whether the log line is an A or AAAA query.
+func isQuery(line []byte) bool {
+	for _, action := range queryActions {
+		if bytes.Contains(line, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dnsmasqTimeLayout is the timestamp each dnsmasq log line starts with.
+const dnsmasqTimeLayout = "Jan _2 15:04:05"
+
+// lineTime parses the timestamp the log line starts with, reporting whether there is one.
+// As dnsmasq doesn't log the year, the latest year not putting the timestamp in the future of `now` is assumed;
+// a day of slack covers clocks that are slightly off.
+func lineTime(line []byte, now time.Time) (time.Time, bool) {
+	if len(line) < len(dnsmasqTimeLayout) {
+		return time.Time{}, false
+	}
+	ts, err := time.ParseInLocation(dnsmasqTimeLayout, string(line[:len(dnsmasqTimeLayout)]), now.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	ts = ts.AddDate(now.Year(), 0, 0)
+	if ts.After(now.AddDate(0, 0, 1)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+
+	return ts, true
+}
+
+// excluded reports whether the domain matches any of the exclude patterns, which win over the match patterns.
+func (e *Extractor) excluded(domain []byte) bool {
+	for _, rgx := range e.opts.Excludes {
+		if rgx.Match(domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeDomain returns the matched domain the way pihole expects it, so variants of the same domain
+// are counted once: lowercased as domains are case-insensitive, without a `:port` suffix nor trailing dots.
+// A trailing character no domain holds, e.g. the space ending the match of `DefaultMatchPattern`, is cut too.
+// The domain is only copied when it needs lowercasing, it is sliced otherwise.
+func normalizeDomain(domain []byte) []byte {
+	domain = bytes.TrimRightFunc(domain, notDomainRune)
+	if i := bytes.LastIndexByte(domain, ':'); i >= 0 {
+		domain = domain[:i]
+	}
+	domain = bytes.TrimRight(domain, ".")
+	for _, c := range domain {
+		if ('A' <= c && c <= 'Z') || c >= utf8.RuneSelf {
+			return bytes.ToLower(domain)
+		}
+	}
+
+	return domain
+}
+
+// notDomainRune reports whether the character cannot be part of a domain, internationalised ones included.
+func notDomainRune(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '.', r == ':':
+		return false
+	}
+
+	return r < utf8.RuneSelf
+}
+
+// counter counts the matched domains: the registry itself, or the tally of a single file.
+type counter interface {
+	count(domain []byte)
+}
+
+// tally counts the domains matched in a single file. A domain matched again, by far the most common case,
+// is counted without allocating its string nor locking the registry.
+type tally map[string]*int
+
+// count adds an occurrence of the domain.
+func (t tally) count(domain []byte) {
+	// The conversion in the lookup doesn't allocate.
+	if n := t[string(domain)]; n != nil {
+		*n++
+		return
+	}
+	n := 1
+	t[string(domain)] = &n
+}
+
+// Match inserts every match found in `line` into the registry, and passes it to `Options.OnMatch`
+// along with where the line comes from. No line filter applies.
+// It returns the number of matches found.
+func (e *Extractor) Match(line []byte, registry *DomainMap, file string, lineNumber int) int {
+	return e.match(line, registry, file, lineNumber)
+}
+
+// match passes every match found in `line` to the counter, and to `Options.OnMatch`.
+// It returns the number of matches found.
+func (e *Extractor) match(line []byte, c counter, file string, lineNumber int) int {
+	var matches int
+	for _, rgx := range e.opts.Matchers {
+		for _, loc := range rgx.FindAllIndex(line, -1) {
+			domain := normalizeDomain(line[loc[0]:loc[1]])
+			if len(domain) == 0 || e.excluded(domain) {
+				continue
+			}
+			c.count(domain)
+			if e.opts.OnMatch != nil {
+				e.opts.OnMatch(string(domain), file, lineNumber)
+			}
+			matches++
+		}
+	}
+	if matches > 0 && e.debugging(2) {
+		e.opts.Debugf("Matched line (%v:%v): %s", file, lineNumber, line)
+	}
+
+	return matches
+}
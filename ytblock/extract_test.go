@@ -0,0 +1,500 @@
+package ytblock
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeLog writes the content to a log file named `name` in a temporary directory, returning its path.
+func writeLog(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// processFile runs `ProcessFile` over the file with the given options, returning the domains found.
+func processFile(t *testing.T, opts Options, path string) (map[string]int, FileStats) {
+	t.Helper()
+	e, err := NewExtractor(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewDomainMap(new(sync.Mutex))
+	stats, err := e.ProcessFile(path, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return registry.Domains(), stats
+}
+
+func TestProcessFileTestdata(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		opts  Options
+		want  map[string]int
+		stats FileStats
+	}{
+		{
+			name: "plain",
+			file: "pihole.log",
+			want: map[string]int{
+				"r1---sn-4g5e6nsz.googlevideo.com": 3,
+				"r7---sn-4g5e6nsz.googlevideo.com": 1,
+				"r3---sn-n8v7knez.googlevideo.com": 1,
+			},
+			stats: FileStats{Lines: 9, Matches: 5, Domains: 3},
+		},
+		{
+			name: "gzip",
+			file: "pihole.log.1.gz",
+			want: map[string]int{
+				"r2---sn-4g5e6nsz.googlevideo.com": 1,
+				"r5---sn-aigl6ned.googlevideo.com": 1,
+			},
+			stats: FileStats{Lines: 4, Matches: 2, Domains: 2},
+		},
+		{
+			name: "only queries",
+			file: "pihole.log",
+			opts: Options{OnlyQueries: true},
+			want: map[string]int{
+				"r1---sn-4g5e6nsz.googlevideo.com": 1,
+				"r7---sn-4g5e6nsz.googlevideo.com": 1,
+				"r3---sn-n8v7knez.googlevideo.com": 1,
+			},
+			stats: FileStats{Lines: 9, Matches: 3, Domains: 3},
+		},
+		{
+			name:  "clients",
+			file:  "pihole.log",
+			opts:  Options{Clients: []string{"192.168.1.31"}},
+			want:  map[string]int{"r3---sn-n8v7knez.googlevideo.com": 1},
+			stats: FileStats{Lines: 9, Matches: 1, Domains: 1},
+		},
+		{
+			name:  "exclude",
+			file:  "pihole.log",
+			opts:  Options{ExcludePatterns: []string{`sn-4g5e6nsz`}},
+			want:  map[string]int{"r3---sn-n8v7knez.googlevideo.com": 1},
+			stats: FileStats{Lines: 9, Matches: 1, Domains: 1},
+		},
+		{
+			name: "custom pattern",
+			file: "pihole.log",
+			opts: Options{Patterns: []string{`www\.youtube\.com`, `example\.com`}},
+			want: map[string]int{
+				"www.youtube.com": 2,
+				"example.com":     2,
+			},
+			stats: FileStats{Lines: 9, Matches: 4, Domains: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domains, stats := processFile(t, tt.opts, filepath.Join("..", "testdata", tt.file))
+			if !reflect.DeepEqual(domains, tt.want) {
+				t.Errorf("got domains %v, want %v", domains, tt.want)
+			}
+			if stats != tt.stats {
+				t.Errorf("got stats %+v, want %+v", stats, tt.stats)
+			}
+		})
+	}
+}
+
+func TestProcessFileLongLines(t *testing.T) {
+	const domain = "r2---sn-abc.googlevideo.com"
+	long := strings.Repeat("x", 128*1024) + " " + domain
+	tests := []struct {
+		name       string
+		content    string
+		bufferSize int
+	}{
+		{"128KB line", long + "\n", 0},
+		{"128KB line without newline", long, 0},
+		{"longer than the buffer without newline", "last " + domain, 16},
+		{"fits the buffer without newline", "last " + domain, 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeLog(t, "pihole.log", tt.content)
+			domains, stats := processFile(t, Options{ReadBufferBytes: tt.bufferSize}, path)
+			if domains[domain] != 1 || len(domains) != 1 {
+				t.Errorf("got domains %v, want only %v", domains, domain)
+			}
+			if stats.Lines != 1 {
+				t.Errorf("got (%v) lines, want 1", stats.Lines)
+			}
+		})
+	}
+}
+
+func TestProcessFileMaxLineBytes(t *testing.T) {
+	const domain = "r2---sn-abc.googlevideo.com"
+	long := strings.Repeat("x", 1024) + " r1---sn-long.googlevideo.com"
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"line over the limit", long + "\n" + domain + "\n"},
+		{"last line over the limit without newline", domain + "\n" + long},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeLog(t, "pihole.log", tt.content)
+			domains, stats := processFile(t, Options{ReadBufferBytes: 16, MaxLineBytes: 512}, path)
+			if domains[domain] != 1 || len(domains) != 1 {
+				t.Errorf("got domains %v, want only %v", domains, domain)
+			}
+			if stats.Lines != 2 {
+				t.Errorf("got (%v) lines, want 2", stats.Lines)
+			}
+		})
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"r1---sn-abc.googlevideo.com", "r1---sn-abc.googlevideo.com"},
+		{"R1---SN-ABC.GoogleVideo.COM", "r1---sn-abc.googlevideo.com"},
+		{"r1---sn-abc.googlevideo.com.", "r1---sn-abc.googlevideo.com"},
+		{"r1---sn-abc.googlevideo.com:443", "r1---sn-abc.googlevideo.com"},
+		{"r1---sn-abc.googlevideo.com.:443", "r1---sn-abc.googlevideo.com"},
+		{"r1---sn-abc.googlevideo.com ", "r1---sn-abc.googlevideo.com"},
+		{`r1---sn-abc.googlevideo.com"`, "r1---sn-abc.googlevideo.com"},
+		{"r1---sn-abc.googlevideo.com..", "r1---sn-abc.googlevideo.com"},
+		{"example.com:8080", "example.com"},
+		{"example.com:", "example.com"},
+		{"EXAMPLE.com.", "example.com"},
+		{"bücher.example", "bücher.example"},
+		{"BÜCHER.example", "bücher.example"},
+		{"...", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := string(normalizeDomain([]byte(tt.domain))); got != tt.want {
+			t.Errorf("normalizeDomain(%q): got %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultMatchPattern(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"query[A] r4---sn-4g5edned.googlevideo.com from 10.0.0.2", "r4---sn-4g5edned.googlevideo.com"},
+		{"query[A] rr1---sn-abc.googlevideo.com from 10.0.0.2", "rr1---sn-abc.googlevideo.com"},
+		{"query[A] r12---sn-abc.googlevideo.com from 10.0.0.2", "r12---sn-abc.googlevideo.com"},
+		{"query[A] r3-sn-abc.googlevideo.com from 10.0.0.2", "r3-sn-abc.googlevideo.com"},
+		{"query[A] R5---SN-ABC.GoogleVideo.com from 10.0.0.2", "r5---sn-abc.googlevideo.com"},
+		{"reply r1---sn-a-b1.googlevideo.com is 1.2.3.4", "r1---sn-a-b1.googlevideo.com"},
+		{"r1---sn-abc.googlevideo.com", "r1---sn-abc.googlevideo.com"},
+		{`{"name":"r1---sn-abc.googlevideo.com","type":"A"}`, "r1---sn-abc.googlevideo.com"},
+		{"forwarded r1---sn-abc.googlevideo.com.:443 to 1.1.1.1", "r1---sn-abc.googlevideo.com"},
+		{"query[A] r1---sn-abc.googlevideo.community from 10.0.0.2", ""},
+		{"query[A] r1---sn-abc.googlevideo.com-x.net from 10.0.0.2", ""},
+		{"query[A] r1---sn-abc.googlevideo.com.evil.net from 10.0.0.2", ""},
+		{"query[A] r1---sn-abc.googlevideo.com_x.net from 10.0.0.2", ""},
+		{"query[A] r1---sn-abc.googlevideo.com/x from 10.0.0.2", ""},
+		{"query[A] r1---sn-abc.googlevideo.com. from 10.0.0.2", "r1---sn-abc.googlevideo.com"},
+		{"r1---sn-abc.googlevideo.com.", "r1---sn-abc.googlevideo.com"},
+		{"query[A] r1---sn-abc.googlevideoxcom from 10.0.0.2", ""},
+		{"query[A] r1---sn-abcxgooglevideoxcom from 10.0.0.2", ""},
+		{"query[A] rrr1---sn-abc.googlevideo.com from 10.0.0.2", ""},
+		{"query[A] xr1---sn-abc.googlevideo.com from 10.0.0.2", ""},
+		{"query[A] r---sn-abc.googlevideo.com from 10.0.0.2", ""},
+		{"query[A] www.youtube.com from 10.0.0.2", ""},
+	}
+	e, err := NewExtractor(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			registry := NewDomainMap(new(sync.Mutex))
+			e.Match([]byte(tt.line), registry, "pihole.log", 1)
+			domains := registry.Domains()
+			switch {
+			case tt.want == "" && len(domains) > 0:
+				t.Errorf("got domains %v, want none", domains)
+			case tt.want != "" && (len(domains) != 1 || domains[tt.want] != 1):
+				t.Errorf("got domains %v, want only %v", domains, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindLogFilesPrefix(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"pihole.log", "pihole.log.1", "pihole.log.2.gz", "dnsmasq.log", "FTL.log", "sub/pihole.log.3"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		prefix     string
+		recursive  bool
+		skipActive bool
+		want       []string
+	}{
+		{"pihole", "pihole.log", false, false, []string{"pihole.log", "pihole.log.1", "pihole.log.2.gz"}},
+		{"custom prefix", "dnsmasq", false, false, []string{"dnsmasq.log"}},
+		{"no match", "unbound", false, false, []string{}},
+		{"skip active", "pihole.log", false, true, []string{"pihole.log.1", "pihole.log.2.gz"}},
+		{"recursive", "pihole.log", true, false, []string{"pihole.log", "pihole.log.1", "pihole.log.2.gz", "sub/pihole.log.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, err := FindLogFiles(dir, tt.prefix, tt.recursive, tt.skipActive, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(paths))
+			for _, p := range paths {
+				rel, err := filepath.Rel(dir, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessFileGzipByContent(t *testing.T) {
+	const line = "query[A] r1---sn-abc.googlevideo.com from 10.0.0.2\n"
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"compressed without suffix", "pihole.log.1", compressed.String()},
+		{"compressed with suffix", "pihole.log.1.gz", compressed.String()},
+		{"plain with suffix", "pihole.log.1.gz", line},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domains, _ := processFile(t, Options{}, writeLog(t, tt.file, tt.content))
+			if want := map[string]int{"r1---sn-abc.googlevideo.com": 1}; !reflect.DeepEqual(domains, want) {
+				t.Errorf("got domains %v, want %v", domains, want)
+			}
+		})
+	}
+}
+
+func TestFindLogFilesSeparator(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pihole.log"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "pihole.log")}
+
+	for _, d := range []string{dir, dir + string(filepath.Separator), dir + string(filepath.Separator) + "."} {
+		for _, recursive := range []bool{false, true} {
+			got, err := FindLogFiles(d, "pihole.log", recursive, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("FindLogFiles(%q, recursive: %v): got %v, want %v", d, recursive, got, want)
+			}
+		}
+	}
+}
+
+func TestProcessFileMixedCase(t *testing.T) {
+	content := "query[A] r1---sn-abc.googlevideo.com from 10.0.0.2\n" +
+		"query[A] R1---SN-ABC.GOOGLEVIDEO.COM from 10.0.0.2\n" +
+		"query[A] r1---Sn-Abc.GoogleVideo.Com from 10.0.0.2\n"
+	domains, stats := processFile(t, Options{}, writeLog(t, "pihole.log", content))
+	if want := map[string]int{"r1---sn-abc.googlevideo.com": 3}; !reflect.DeepEqual(domains, want) {
+		t.Errorf("got domains %v, want %v", domains, want)
+	}
+	if stats.Domains != 1 {
+		t.Errorf("got (%v) unique domains, want 1", stats.Domains)
+	}
+}
+
+// benchLog returns the content of a log of `lines` lines, a fourth of them querying one of a hundred video hosts.
+func benchLog(lines int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < lines; i++ {
+		if i%4 == 0 {
+			fmt.Fprintf(&b, "Jan  2 15:04:05 dnsmasq[512]: query[A] r%v---sn-4g5e6nsz.googlevideo.com from 192.168.1.20\n", i%100)
+		} else {
+			fmt.Fprintf(&b, "Jan  2 15:04:05 dnsmasq[512]: reply host%v.example.com is 93.184.216.34\n", i%1000)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// writeBenchLogs writes `files` logs of `lines` lines each to a temporary directory, gzip compressed when `compress` is set.
+func writeBenchLogs(b *testing.B, files, lines int, compress bool) []string {
+	b.Helper()
+	content := benchLog(lines)
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(content); err != nil {
+			b.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			b.Fatal(err)
+		}
+		content = buf.Bytes()
+	}
+
+	dir := b.TempDir()
+	paths := make([]string, files)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("pihole.log.%v", i+1))
+		if err := os.WriteFile(paths[i], content, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return paths
+}
+
+func BenchmarkProcessFileGzip(b *testing.B) {
+	path := writeBenchLogs(b, 1, 100000, true)[0]
+	e, err := NewExtractor(Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.ProcessFile(path, NewDomainMap(new(sync.Mutex))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExcludePatterns(t *testing.T) {
+	line := []byte("query[A] r1---sn-abc.googlevideo.com from 10.0.0.2 ads.example.com tracker.example.net")
+	tests := []struct {
+		name     string
+		patterns []string
+		excludes []string
+		want     []string
+	}{
+		{"include only", []string{`[a-z0-9.-]+\.example\.(com|net)`}, nil, []string{"ads.example.com", "tracker.example.net"}},
+		{"exclude wins", []string{`[a-z0-9.-]+\.example\.(com|net)`}, []string{`^ads\.`}, []string{"tracker.example.net"}},
+		{"exclude everything", []string{`[a-z0-9.-]+\.example\.(com|net)`}, []string{`example`}, []string{}},
+		{"exclude default pattern", nil, []string{`sn-abc`}, []string{}},
+		{"exclude unrelated", nil, []string{`sn-xyz`}, []string{"r1---sn-abc.googlevideo.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewExtractor(Options{Patterns: tt.patterns, ExcludePatterns: tt.excludes})
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry := NewDomainMap(new(sync.Mutex))
+			e.Match(line, registry, "pihole.log", 1)
+			if got := registry.DomainList(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkProcessFile(b *testing.B) {
+	path := writeBenchLogs(b, 1, 100000, false)[0]
+	e, err := NewExtractor(Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.ProcessFile(path, NewDomainMap(new(sync.Mutex))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkProcessFiles compares the pooled readers of an extractor reused across a hundred files
+// with an extractor made for every file, whose pools are always empty. Both share the compiled pattern.
+func BenchmarkProcessFiles(b *testing.B) {
+	opts := Options{Matchers: []*regexp.Regexp{regexp.MustCompile(DefaultMatchPattern)}, Workers: 1}
+	for _, compress := range []bool{false, true} {
+		paths := writeBenchLogs(b, 100, 20, compress)
+		name := "plain"
+		if compress {
+			name = "gzip"
+		}
+
+		b.Run(name+"/pooled", func(b *testing.B) {
+			e, err := NewExtractor(opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				registry := NewDomainMap(new(sync.Mutex))
+				for _, path := range paths {
+					if _, err := e.ProcessFile(path, registry); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(name+"/unpooled", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				registry := NewDomainMap(new(sync.Mutex))
+				for _, path := range paths {
+					e, err := NewExtractor(opts)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := e.ProcessFile(path, registry); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}